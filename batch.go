@@ -0,0 +1,236 @@
+package lirc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Batch collects commands to be sent to lircd in order
+type Batch struct {
+	router *Router
+	steps  []func() error
+}
+
+// NewBatch returns an empty Batch for the router
+func (l *Router) NewBatch() *Batch {
+	return &Batch{router: l}
+}
+
+// Send adds a SEND_ONCE command for button on remote to the batch
+func (b *Batch) Send(remote string, button string) *Batch {
+	b.steps = append(b.steps, func() error {
+		return b.router.Send(remote + " " + button)
+	})
+	return b
+}
+
+// SendLong adds a SEND_START / SEND_STOP pair for button on remote to the batch
+func (b *Batch) SendLong(remote string, button string, delay time.Duration) *Batch {
+	b.steps = append(b.steps, func() error {
+		return b.router.SendLong(remote+" "+button, delay)
+	})
+	return b
+}
+
+// Execute sends all commands in the order they were added. The returned slice
+// holds one entry per command, nil on success. Commands not yet sent when ctx
+// is done are skipped and report the context error.
+func (b *Batch) Execute(ctx context.Context) []error {
+	errs := make([]error, len(b.steps))
+	for i, step := range b.steps {
+		if err := ctx.Err(); err != nil {
+			errs[i] = err
+			continue
+		}
+		errs[i] = step()
+	}
+	return errs
+}
+
+// SendAll sends a SEND_ONCE command for button to each of remotes
+// concurrently and returns the result per remote. Remotes not yet sent to when
+// ctx is done report the context error.
+func (l *Router) SendAll(ctx context.Context, remotes []string, button string) map[string]error {
+	workers := l.config.sendAllConcurrency
+	if workers <= 0 {
+		workers = defaultSendAllConcurrency
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]error, len(remotes))
+	jobs := make(chan string)
+
+	for i := 0; i < workers && i < len(remotes); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for remote := range jobs {
+				err := ctx.Err()
+				if err == nil {
+					err = l.Send(remote + " " + button)
+				}
+				mu.Lock()
+				results[remote] = err
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, remote := range remotes {
+		jobs <- remote
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// sendLocked writes command and waits for a successful reply. The caller must
+// hold l.cmdLock.
+func (l *Router) sendLocked(ctx context.Context, command string) (Reply, error) {
+	if err := ctx.Err(); err != nil {
+		return errorReply(command, err), err
+	}
+
+	start := time.Now()
+	reply, cancel, err := l.write(command)
+	if err != nil {
+		r := errorReply(command, err)
+		l.record(command, start, r, err)
+		return r, err
+	}
+	r, err := l.wait(ctx, command, reply, cancel, 0)
+	l.record(command, start, r, err)
+	if err != nil {
+		return r, err
+	}
+	return r, replyError(r)
+}
+
+// BurstPartialError is returned by SendBurst if not all sends completed
+type BurstPartialError struct {
+	Sent  int
+	Total int
+	Err   error
+}
+
+func (e *BurstPartialError) Error() string {
+	return fmt.Sprintf("burst stopped after %d of %d sends: %v", e.Sent, e.Total, e.Err)
+}
+
+func (e *BurstPartialError) Unwrap() error {
+	return e.Err
+}
+
+// SendBurst sends button on remote count times, waiting interval between the
+// sends. No other command is written until the burst is over. If ctx is done
+// or a send fails, the burst stops with a *BurstPartialError.
+func (l *Router) SendBurst(ctx context.Context, remote string, button string, count int, interval time.Duration) error {
+	command := "SEND_ONCE " + l.resolveRemote(remote) + " " + button
+
+	l.cmdLock.Lock()
+	defer l.cmdLock.Unlock()
+
+	for sent := 0; sent < count; sent++ {
+		if sent > 0 {
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return &BurstPartialError{Sent: sent, Total: count, Err: ctx.Err()}
+			}
+		}
+
+		if _, err := l.sendLocked(ctx, command); err != nil {
+			return &BurstPartialError{Sent: sent, Total: count, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// SendSpec is a single command of a SendBatch. It is implemented by SendOnce,
+// SendLong and Simulate.
+type SendSpec interface {
+	send(ctx context.Context, l *Router) (Reply, error)
+}
+
+// SendOnce sends a SEND_ONCE command for Button on Remote
+type SendOnce struct {
+	Remote string
+	Button string
+}
+
+func (s SendOnce) send(ctx context.Context, l *Router) (Reply, error) {
+	return l.sendLocked(ctx, "SEND_ONCE "+l.resolveRemote(s.Remote)+" "+s.Button)
+}
+
+// SendLong holds Button on Remote for Delay using SEND_START / SEND_STOP. If
+// ctx is done before Delay is over, the button is released early and the
+// context error is reported.
+type SendLong struct {
+	Remote string
+	Button string
+	Delay  time.Duration
+}
+
+func (s SendLong) send(ctx context.Context, l *Router) (Reply, error) {
+	command := l.resolveRemote(s.Remote) + " " + s.Button
+	if r, err := l.sendLocked(ctx, "SEND_START "+command); err != nil {
+		return r, err
+	}
+
+	var err error
+	timer := time.NewTimer(s.Delay)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+		err = ctx.Err()
+	}
+
+	// always release the button, even if ctx is done meanwhile
+	r, stopErr := l.sendLocked(context.Background(), "SEND_STOP "+command)
+	if err == nil {
+		err = stopErr
+	}
+	return r, err
+}
+
+// Simulate makes lircd broadcast an event as if Button on Remote was received
+type Simulate struct {
+	Code   uint64
+	Repeat int64
+	Button string
+	Remote string
+}
+
+func (s Simulate) send(ctx context.Context, l *Router) (Reply, error) {
+	return l.sendLocked(ctx, fmt.Sprintf("SIMULATE %016x %02x %s %s", s.Code, s.Repeat, s.Button, l.resolveRemote(s.Remote)))
+}
+
+// SendResult is the outcome of a single SendSpec
+type SendResult struct {
+	Reply Reply
+	Err   error
+}
+
+// SendBatch sends all specs in order without other commands in between. The
+// returned slice holds one result per spec. A failed spec does not stop the
+// batch; specs not yet sent when ctx is done report the context error.
+func (l *Router) SendBatch(ctx context.Context, batch []SendSpec) []SendResult {
+	results := make([]SendResult, len(batch))
+
+	l.cmdLock.Lock()
+	defer l.cmdLock.Unlock()
+
+	for i, spec := range batch {
+		results[i].Reply, results[i].Err = spec.send(ctx, l)
+	}
+
+	return results
+}
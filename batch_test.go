@@ -0,0 +1,213 @@
+package lirc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	f.failOn("KEY_BAD")
+	errs := l.NewBatch().
+		Send("TV", "KEY_1").
+		SendLong("TV", "KEY_2", 10*time.Millisecond).
+		Send("TV", "KEY_BAD").
+		Execute(context.Background())
+
+	if len(errs) != 3 || errs[0] != nil || errs[1] != nil || errs[2] == nil {
+		t.Fatalf("got errors %v, expected only the third to fail", errs)
+	}
+
+	for _, expected := range []string{
+		"SEND_ONCE TV KEY_1",
+		"SEND_START TV KEY_2",
+		"SEND_STOP TV KEY_2",
+		"SEND_ONCE TV KEY_BAD",
+	} {
+		if command := f.next(t); command != expected {
+			t.Fatalf("lircd read %q, expected %q", command, expected)
+		}
+	}
+}
+
+func TestBatchCanceled(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errs := l.NewBatch().Send("TV", "KEY_1").Send("TV", "KEY_2").Execute(ctx)
+	for i, err := range errs {
+		if err != context.Canceled {
+			t.Errorf("command %d: got %v, expected context.Canceled", i, err)
+		}
+	}
+	select {
+	case command := <-f.commands:
+		t.Fatalf("%q sent after cancel", command)
+	default:
+	}
+}
+
+func TestSendAll(t *testing.T) {
+	l, f := newTestRouter(t, WithSendAllConcurrency(2))
+	defer l.Close()
+
+	f.failOn("MON3")
+	remotes := []string{"MON1", "MON2", "MON3", "MON4", "MON5"}
+	results := l.SendAll(context.Background(), remotes, "KEY_POWER")
+
+	if len(results) != len(remotes) {
+		t.Fatalf("got %d results for %d remotes", len(results), len(remotes))
+	}
+	for _, remote := range remotes {
+		err, ok := results[remote]
+		if !ok {
+			t.Errorf("no result for %s", remote)
+		} else if (err != nil) != (remote == "MON3") {
+			t.Errorf("%s: unexpected result %v", remote, err)
+		}
+	}
+
+	sent := make(map[string]bool)
+	for range remotes {
+		sent[f.next(t)] = true
+	}
+	for _, remote := range remotes {
+		if !sent["SEND_ONCE "+remote+" KEY_POWER"] {
+			t.Errorf("nothing sent to %s", remote)
+		}
+	}
+}
+
+func TestSendBurst(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	start := time.Now()
+	if err := l.SendBurst(context.Background(), "TV", "KEY_CHANNELUP", 3, 20*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("burst took %v, expected at least two intervals", d)
+	}
+	for i := 0; i < 3; i++ {
+		if command := f.next(t); command != "SEND_ONCE TV KEY_CHANNELUP" {
+			t.Fatalf("lircd read %q", command)
+		}
+	}
+}
+
+func TestSendBurstCanceled(t *testing.T) {
+	l, _ := newTestRouter(t)
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// cancel during the interval after the second send
+	records := l.Commands()
+	go func() {
+		<-records
+		<-records
+		cancel()
+	}()
+
+	err := l.SendBurst(ctx, "TV", "KEY_CHANNELUP", 5, 100*time.Millisecond)
+	var partial *BurstPartialError
+	if !errors.As(err, &partial) {
+		t.Fatalf("got %v, expected a BurstPartialError", err)
+	}
+	if partial.Sent != 2 || partial.Total != 5 || !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %+v, expected 2 of 5 sent and context.Canceled", partial)
+	}
+}
+
+func TestSendBurstFailure(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	f.failOn("KEY_BAD")
+	err := l.SendBurst(context.Background(), "TV", "KEY_BAD", 3, time.Millisecond)
+	var partial *BurstPartialError
+	if !errors.As(err, &partial) || partial.Sent != 0 {
+		t.Fatalf("got %v, expected a BurstPartialError with nothing sent", err)
+	}
+}
+
+func TestSendBatch(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	f.failOn("KEY_BAD")
+	results := l.SendBatch(context.Background(), []SendSpec{
+		SendOnce{Remote: "TV", Button: "KEY_1"},
+		SendOnce{Remote: "TV", Button: "KEY_BAD"},
+		SendLong{Remote: "TV", Button: "KEY_2", Delay: 10 * time.Millisecond},
+		Simulate{Code: 0x37ff07bef, Repeat: 0x1a, Button: "KEY_3", Remote: "TV"},
+	})
+
+	if len(results) != 4 {
+		t.Fatalf("got %d results, expected 4", len(results))
+	}
+	for i, result := range results {
+		if failed := result.Err != nil; failed != (i == 1) {
+			t.Errorf("spec %d: got %v, expected only the second to fail", i, result.Err)
+		}
+	}
+	if reply := results[2].Reply; reply.Command != "SEND_STOP TV KEY_2" {
+		t.Errorf("SendLong returned the reply to %q", reply.Command)
+	}
+
+	for _, expected := range []string{
+		"SEND_ONCE TV KEY_1",
+		"SEND_ONCE TV KEY_BAD",
+		"SEND_START TV KEY_2",
+		"SEND_STOP TV KEY_2",
+		"SIMULATE 000000037ff07bef 1a KEY_3 TV",
+	} {
+		if command := f.next(t); command != expected {
+			t.Fatalf("lircd read %q, expected %q", command, expected)
+		}
+	}
+}
+
+func TestSendBatchCanceled(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	results := l.SendBatch(ctx, []SendSpec{
+		SendOnce{Remote: "TV", Button: "KEY_1"},
+		SendLong{Remote: "TV", Button: "KEY_2", Delay: time.Minute},
+		SendOnce{Remote: "TV", Button: "KEY_3"},
+	})
+
+	if results[0].Err != nil {
+		t.Errorf("first spec: %v", results[0].Err)
+	}
+	for _, i := range []int{1, 2} {
+		if !errors.Is(results[i].Err, context.DeadlineExceeded) {
+			t.Errorf("spec %d: got %v, expected %v", i, results[i].Err, context.DeadlineExceeded)
+		}
+	}
+
+	// the held button is released, nothing is sent afterwards
+	for _, expected := range []string{"SEND_ONCE TV KEY_1", "SEND_START TV KEY_2", "SEND_STOP TV KEY_2"} {
+		if command := f.next(t); command != expected {
+			t.Fatalf("lircd read %q, expected %q", command, expected)
+		}
+	}
+	select {
+	case command := <-f.commands:
+		t.Fatalf("%q sent after the deadline", command)
+	default:
+	}
+}
@@ -0,0 +1,298 @@
+package lirc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The tests in this file describe how the reader handles each kind of message
+// lircd sends. They serve as acceptance test for changes of reader().
+
+func TestConformanceReplies(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		success int
+		data    []string
+	}{
+		{
+			name:    "success without data",
+			reply:   "BEGIN\nVERSION\nSUCCESS\nEND\n",
+			success: 1,
+		},
+		{
+			name:    "success with data",
+			reply:   "BEGIN\nVERSION\nSUCCESS\nDATA\n1\n0.10.1\nEND\n",
+			success: 1,
+			data:    []string{"0.10.1"},
+		},
+		{
+			name:    "success with several data lines",
+			reply:   "BEGIN\nVERSION\nSUCCESS\nDATA\n3\nTV\nDVD\nAMP\nEND\n",
+			success: 1,
+			data:    []string{"TV", "DVD", "AMP"},
+		},
+		{
+			name:    "zero-length data",
+			reply:   "BEGIN\nVERSION\nSUCCESS\nDATA\n0\nEND\n",
+			success: 1,
+		},
+		{
+			name:    "error with data",
+			reply:   "BEGIN\nVERSION\nERROR\nDATA\n1\nunknown remote: \"TV\"\nEND\n",
+			success: 0,
+			data:    []string{"unknown remote: \"TV\""},
+		},
+		{
+			name:    "error without data",
+			reply:   "BEGIN\nVERSION\nERROR\nEND\n",
+			success: 0,
+		},
+		{
+			name:    "end right after the command",
+			reply:   "BEGIN\nVERSION\nEND\n",
+			success: 1,
+		},
+		{
+			name:    "carriage returns",
+			reply:   "BEGIN\r\nVERSION\r\nSUCCESS\r\nDATA\r\n1\r\n0.10.1\r\nEND\r\n",
+			success: 1,
+			data:    []string{"0.10.1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, f := newTestRouter(t)
+			defer l.Close()
+
+			f.setReply(func(string) string { return tt.reply })
+
+			reply, _ := l.SendCommandString("VERSION")
+			if reply.Command != "VERSION" {
+				t.Errorf("Command = %q, expected VERSION", reply.Command)
+			}
+			if reply.Success != tt.success {
+				t.Errorf("Success = %d, expected %d", reply.Success, tt.success)
+			}
+			if reply.DataLength != len(tt.data) {
+				t.Errorf("DataLength = %d, expected %d", reply.DataLength, len(tt.data))
+			}
+			if !reflect.DeepEqual(reply.Data, tt.data) {
+				t.Errorf("Data = %q, expected %q", reply.Data, tt.data)
+			}
+		})
+	}
+}
+
+func TestConformanceCommandCharacters(t *testing.T) {
+	commands := []string{
+		"SEND_ONCE TV KEY_POWER",
+		"SEND_ONCE \"living room\" KEY_POWER",
+		"SEND_ONCE TV KEY_+/-",
+		"SEND_ONCE TV \\t$%&*?",
+		"SEND_ONCE Télé Lautstärke_hoch",
+	}
+
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	for _, command := range commands {
+		reply, err := l.SendCommandString(command)
+		if err != nil {
+			t.Errorf("%q failed: %v", command, err)
+		}
+		if sent := f.next(t); sent != command {
+			t.Errorf("lircd read %q, expected %q", sent, command)
+		}
+		if reply.Command != command {
+			t.Errorf("reply is for %q, expected %q", reply.Command, command)
+		}
+	}
+}
+
+func TestConformanceEvents(t *testing.T) {
+	long := strings.Repeat("REMOTE", 1000)
+
+	tests := []struct {
+		name  string
+		line  string
+		event Event
+	}{
+		{
+			name:  "plain",
+			line:  "000000037ff07bef 00 KEY_POWER TV\n",
+			event: Event{Code: 0x37ff07bef, Repeat: 0, Button: "KEY_POWER", Remote: "TV"},
+		},
+		{
+			name:  "hex repeat count",
+			line:  "000000037ff07bef 1a KEY_POWER TV\n",
+			event: Event{Code: 0x37ff07bef, Repeat: 26, Button: "KEY_POWER", Remote: "TV"},
+		},
+		{
+			name:  "full width code",
+			line:  "ffffffffffffffff 00 KEY_POWER TV\n",
+			event: Event{Code: 0xffffffffffffffff, Repeat: 0, Button: "KEY_POWER", Remote: "TV"},
+		},
+		{
+			name:  "unicode button",
+			line:  "000000037ff07bef 00 Lautstärke_▲ TV\n",
+			event: Event{Code: 0x37ff07bef, Repeat: 0, Button: "Lautstärke_▲", Remote: "TV"},
+		},
+		{
+			name:  "long remote",
+			line:  "000000037ff07bef 00 KEY_POWER " + long + "\n",
+			event: Event{Code: 0x37ff07bef, Repeat: 0, Button: "KEY_POWER", Remote: long},
+		},
+		{
+			name:  "special characters",
+			line:  "000000037ff07bef 00 KEY_+/-*? TV\"#1\n",
+			event: Event{Code: 0x37ff07bef, Repeat: 0, Button: "KEY_+/-*?", Remote: "TV\"#1"},
+		},
+		{
+			name:  "carriage return",
+			line:  "000000037ff07bef 00 KEY_POWER TV\r\n",
+			event: Event{Code: 0x37ff07bef, Repeat: 0, Button: "KEY_POWER", Remote: "TV"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, f := newTestRouter(t)
+			defer l.Close()
+
+			s := l.subscribe("", nil)
+			defer l.unsubscribe(s)
+			go l.Run()
+
+			f.send(t, tt.line)
+			if event := receiveEvent(t, s.events); event != tt.event {
+				t.Errorf("got %+v, expected %+v", event, tt.event)
+			}
+		})
+	}
+}
+
+func TestConformanceInvalidEvents(t *testing.T) {
+	lines := []string{
+		"\n",
+		"KEY_POWER\n",
+		"000000037ff07bef 00 KEY_POWER\n",
+		"000000037ff07bef 00 KEY_POWER TV extra\n",
+		"not-a-hex-code!! 00 KEY_POWER TV\n",
+		"37ff07bef 00 KEY_POWER TV\n",
+		"SIGHUP\n",
+	}
+
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	s := l.subscribe("", nil)
+	defer l.unsubscribe(s)
+	go l.Run()
+
+	for _, line := range lines {
+		f.send(t, line)
+	}
+	f.event(t, "TV", "KEY_OK", 0)
+
+	if event := receiveEvent(t, s.events); event.Button != "KEY_OK" {
+		t.Fatalf("invalid line dispatched as %+v", event)
+	}
+
+	// replies are still parsed after invalid lines
+	if _, err := l.SendCommandString("VERSION"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConformanceInterleavedEvent(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	events := make(chan Event, 1)
+	l.Handle("TV", "KEY_POWER", func(event Event) {
+		events <- event
+	})
+	go l.Run()
+
+	// lircd broadcasts events between replies, never within one
+	f.setReply(func(command string) string {
+		return eventLine("TV", "KEY_POWER", 0) + lircdSuccess(command)
+	})
+
+	if _, err := l.SendCommandString("VERSION"); err != nil {
+		t.Fatal(err)
+	}
+	receiveEvent(t, events)
+}
+
+func TestConformanceSighupReply(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	// lircd sends a reply to SIGHUP without a command asking for it
+	f.send(t, "BEGIN\nSIGHUP\nEND\n")
+
+	reply, ok := l.PeekReply(time.Second)
+	if !ok || reply.Command != "SIGHUP" {
+		t.Fatalf("got %+v, %v", reply, ok)
+	}
+}
+
+func TestConformanceInvalidReplies(t *testing.T) {
+	replies := []string{
+		"BEGIN\nVERSION\nMAYBE\n",
+		"BEGIN\nVERSION\nSUCCESS\nNODATA\n",
+		"BEGIN\nVERSION\nSUCCESS\nDATA\n-1\n",
+		"BEGIN\nVERSION\nSUCCESS\nDATA\nmany\n",
+		"BEGIN\nVERSION\nSUCCESS\nDATA\n1\n0.10.1\nMORE\n",
+	}
+
+	for _, invalid := range replies {
+		l, f := newTestRouter(t)
+
+		// the invalid reply is dropped, the parser is ready for the next one
+		f.send(t, invalid)
+		f.send(t, "BEGIN\nSIGHUP\nEND\n")
+
+		reply, ok := l.PeekReply(time.Second)
+		if !ok || reply.Command != "SIGHUP" {
+			t.Errorf("after %q: got %+v, %v", invalid, reply, ok)
+		}
+		l.Close()
+	}
+}
+
+func TestConformanceInvalidReplyPending(t *testing.T) {
+	replies := []string{
+		"BEGIN\nVERSION\nMAYBE\n",
+		"BEGIN\nVERSION\nSUCCESS\nNODATA\n",
+		"BEGIN\nVERSION\nSUCCESS\nDATA\n-1\n",
+		"BEGIN\nVERSION\nSUCCESS\nDATA\n1\n0.10.1\nMORE\n",
+	}
+
+	for _, invalid := range replies {
+		l, f := newTestRouter(t)
+
+		f.setReply(func(command string) string {
+			if command == "VERSION" {
+				return invalid
+			}
+			return lircdSuccess(command)
+		})
+
+		// the pending command fails instead of taking the next reply
+		reply, _ := l.SendCommandString("VERSION")
+		if reply.Command != "VERSION" || reply.Success != 0 || len(reply.Data) != 1 {
+			t.Errorf("after %q: VERSION got %+v", invalid, reply)
+		}
+		reply, err := l.SendCommandString("LIST")
+		if err != nil || reply.Command != "LIST" || reply.Success != 1 {
+			t.Errorf("after %q: LIST got %+v, %v", invalid, reply, err)
+		}
+		l.Close()
+	}
+}
@@ -0,0 +1,101 @@
+package lirc
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+// routerState is the diagnostic view of a Router written by Dump
+type routerState struct {
+	Connected     bool              `json:"connected"`
+	Running       bool              `json:"running"`
+	Path          string            `json:"path,omitempty"`
+	Host          string            `json:"host,omitempty"`
+	Mode          string            `json:"mode"`
+	Handlers      []RemoteButton    `json:"handlers"`
+	Patterns      []RemoteButton    `json:"patterns,omitempty"`
+	Topics        []string          `json:"topics,omitempty"`
+	RemoteEvent   bool              `json:"remoteEventHandler"`
+	RemoteAliases map[string]string `json:"remoteAliases,omitempty"`
+	Subscriptions int               `json:"subscriptions"`
+	Groups        []string          `json:"groups,omitempty"`
+	Stats         routerStats       `json:"stats"`
+}
+
+// routerStats holds the counters of a Router written by Dump
+type routerStats struct {
+	Events  uint64        `json:"events"`
+	Dropped uint64        `json:"dropped"`
+	Buttons []buttonCount `json:"buttons"`
+}
+
+// buttonCount is the number of events dispatched for a single button
+type buttonCount struct {
+	RemoteButton
+	Count uint64 `json:"count"`
+}
+
+// Dump returns the current state of the router as JSON, for use in bug
+// reports and debugging
+func (l *Router) Dump() ([]byte, error) {
+	state := routerState{
+		Connected: !l.closed(),
+		Running:   l.isRunning(),
+		Path:      l.path,
+		Host:      l.host,
+	}
+
+	l.lock.RLock()
+	state.Handlers = make([]RemoteButton, 0, len(l.handlers))
+	for rb := range l.handlers {
+		state.Handlers = append(state.Handlers, rb)
+	}
+	for _, p := range l.patterns {
+		state.Patterns = append(state.Patterns, RemoteButton{p.remote, string(p.pattern)})
+	}
+	for _, h := range l.topics {
+		state.Topics = append(state.Topics, h.topic)
+	}
+	state.Mode = l.mode
+	state.RemoteEvent = l.remoteEvent != nil
+	if len(l.remoteAliases) > 0 {
+		state.RemoteAliases = make(map[string]string, len(l.remoteAliases))
+		for alias, canonical := range l.remoteAliases {
+			state.RemoteAliases[alias] = canonical
+		}
+	}
+	state.Subscriptions = len(l.subscriptions)
+	for s := range l.subscriptions {
+		if s.name != "" {
+			state.Groups = append(state.Groups, s.name)
+		}
+	}
+	l.lock.RUnlock()
+
+	state.Stats.Dropped = l.dropped.Load()
+	l.countLock.Lock()
+	state.Stats.Buttons = make([]buttonCount, 0, len(l.counts))
+	for rb, n := range l.counts {
+		state.Stats.Events += n
+		state.Stats.Buttons = append(state.Stats.Buttons, buttonCount{rb, n})
+	}
+	l.countLock.Unlock()
+
+	sort.Strings(state.Groups)
+	sort.Slice(state.Handlers, func(i, j int) bool {
+		return lessRemoteButton(state.Handlers[i], state.Handlers[j])
+	})
+	sort.Slice(state.Stats.Buttons, func(i, j int) bool {
+		return lessRemoteButton(state.Stats.Buttons[i].RemoteButton, state.Stats.Buttons[j].RemoteButton)
+	})
+
+	return json.MarshalIndent(state, "", "  ")
+}
+
+// lessRemoteButton orders by remote, then button
+func lessRemoteButton(a RemoteButton, b RemoteButton) bool {
+	if a.Remote != b.Remote {
+		return a.Remote < b.Remote
+	}
+	return a.Button < b.Button
+}
@@ -0,0 +1,78 @@
+package lirc
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	l.Handle("TV", "KEY_POWER", func(Event) {})
+	l.HandlePattern("TV", "*VOL*", func(Event) {})
+	l.AddRemoteAlias("TELEVISION", "TV")
+	l.SetMode("menu")
+	l.WatchGroup(context.Background(), "playback", "DVD", []string{"KEY_PLAY"})
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_POWER"},
+		Event{Remote: "TV", Button: "KEY_POWER"},
+		Event{Remote: "DVD", Button: "KEY_PLAY"},
+	)
+
+	dump, err := l.Dump()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(dump, &keys); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, dump)
+	}
+	for _, key := range []string{"connected", "running", "mode", "handlers", "patterns", "remoteAliases", "subscriptions", "groups", "stats"} {
+		if _, ok := keys[key]; !ok {
+			t.Errorf("key %q missing in %s", key, dump)
+		}
+	}
+
+	var state struct {
+		Mode     string `json:"mode"`
+		Handlers []struct {
+			Remote string `json:"remote"`
+			Button string `json:"button"`
+		} `json:"handlers"`
+		Groups []string `json:"groups"`
+		Stats  struct {
+			Events  uint64 `json:"events"`
+			Dropped uint64 `json:"dropped"`
+			Buttons []struct {
+				Remote string `json:"remote"`
+				Button string `json:"button"`
+				Count  uint64 `json:"count"`
+			} `json:"buttons"`
+		} `json:"stats"`
+	}
+	if err := json.Unmarshal(dump, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Mode != "menu" {
+		t.Errorf("mode = %q", state.Mode)
+	}
+	if len(state.Handlers) != 1 || state.Handlers[0].Remote != "TV" || state.Handlers[0].Button != "KEY_POWER" {
+		t.Errorf("handlers = %+v", state.Handlers)
+	}
+	if len(state.Groups) != 1 || state.Groups[0] != "playback" {
+		t.Errorf("groups = %q", state.Groups)
+	}
+	if state.Stats.Events != 3 || len(state.Stats.Buttons) != 2 {
+		t.Errorf("stats = %+v, expected 3 events for 2 buttons", state.Stats)
+	}
+	for _, b := range state.Stats.Buttons {
+		if b.Remote == "TV" && b.Count != 2 || b.Remote == "DVD" && b.Count != 1 {
+			t.Errorf("%s %s counted %d times", b.Remote, b.Button, b.Count)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package lirc
+
+import (
+	"io"
+)
+
+// EventWriter is implemented by consumers of an event stream
+type EventWriter interface {
+	WriteEvent(Event) error
+}
+
+// EventReader is implemented by sources of an event stream. ReadEvent returns
+// io.EOF at the end of the stream.
+type EventReader interface {
+	ReadEvent() (Event, error)
+}
+
+type chanEventWriter struct {
+	ch chan<- Event
+}
+
+// NewEventWriterChan returns an EventWriter sending each event to ch
+func NewEventWriterChan(ch chan<- Event) EventWriter {
+	return chanEventWriter{ch}
+}
+
+func (w chanEventWriter) WriteEvent(event Event) error {
+	w.ch <- event
+	return nil
+}
+
+type chanEventReader struct {
+	ch <-chan Event
+}
+
+// NewEventReaderChan returns an EventReader receiving events from ch until
+// it is closed
+func NewEventReaderChan(ch <-chan Event) EventReader {
+	return chanEventReader{ch}
+}
+
+func (r chanEventReader) ReadEvent() (Event, error) {
+	event, ok := <-r.ch
+	if !ok {
+		return Event{}, io.EOF
+	}
+	return event, nil
+}
+
+// CopyTo writes every dispatched event to w until Run returns or w fails.
+// Events are only dispatched while Run is active.
+func (l *Router) CopyTo(w EventWriter) error {
+	s := l.subscribe("", nil)
+	defer l.unsubscribe(s)
+
+	for {
+		select {
+		case event, ok := <-s.events:
+			if !ok {
+				return nil
+			}
+			if err := w.WriteEvent(event); err != nil {
+				return err
+			}
+		case <-l.done:
+			return nil
+		}
+	}
+}
+
+// ReadFrom dispatches the events read from r as if they were received from
+// lircd, until r returns io.EOF or another error. Run must be active.
+func (l *Router) ReadFrom(r EventReader) error {
+	for {
+		event, err := r.ReadEvent()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		select {
+		case l.inject <- event:
+		case <-l.done:
+			return ErrClosed
+		}
+	}
+}
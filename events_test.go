@@ -0,0 +1,96 @@
+package lirc
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestEventChanRoundTrip(t *testing.T) {
+	events := []Event{
+		{Code: 1, Button: "KEY_1", Remote: "TV"},
+		{Code: 2, Repeat: 1, Button: "KEY_2", Remote: "TV"},
+		{Code: 3, Button: "KEY_3", Remote: "DVD"},
+	}
+
+	ch := make(chan Event, len(events))
+	w := NewEventWriterChan(ch)
+	for _, event := range events {
+		if err := w.WriteEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(ch)
+
+	r := NewEventReaderChan(ch)
+	for _, expected := range events {
+		event, err := r.ReadEvent()
+		if err != nil || event != expected {
+			t.Fatalf("got %+v, %v, expected %+v", event, err, expected)
+		}
+	}
+	if _, err := r.ReadEvent(); err != io.EOF {
+		t.Fatalf("got %v at the end, expected io.EOF", err)
+	}
+}
+
+func TestCopyToReadFrom(t *testing.T) {
+	l, _ := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	out := make(chan Event, 3)
+	copied := make(chan error)
+	go func() {
+		copied <- l.CopyTo(NewEventWriterChan(out))
+	}()
+	waitSubscribed(t, l, 1)
+
+	in := make(chan Event, 3)
+	events := []Event{
+		{Code: 1, Button: "KEY_1", Remote: "TV"},
+		{Code: 2, Button: "KEY_2", Remote: "TV"},
+		{Code: 3, Button: "KEY_3", Remote: "DVD"},
+	}
+	for _, event := range events {
+		in <- event
+	}
+	close(in)
+
+	if err := l.ReadFrom(NewEventReaderChan(in)); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range events {
+		if event := receiveEvent(t, out); event != expected {
+			t.Fatalf("copied %+v, expected %+v", event, expected)
+		}
+	}
+
+	l.Close()
+	if err := <-copied; err != nil {
+		t.Fatalf("CopyTo returned %v after Close", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) WriteEvent(Event) error {
+	return errors.New("disk full")
+}
+
+func TestCopyToWriteError(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	copied := make(chan error)
+	go func() {
+		copied <- l.CopyTo(failingWriter{})
+	}()
+	waitSubscribed(t, l, 1)
+
+	f.event(t, "TV", "KEY_1", 0)
+	if err := <-copied; err == nil || err.Error() != "disk full" {
+		t.Fatalf("got %v, expected the write error", err)
+	}
+}
@@ -0,0 +1,63 @@
+package lirc
+
+import (
+	"errors"
+	"time"
+)
+
+// HealthStatus is a snapshot of the router state returned by Healthcheck
+type HealthStatus struct {
+	Connected       bool
+	Uptime          time.Duration
+	LastEventAt     time.Time
+	LastCommandAt   time.Time
+	PendingCommands int
+	DroppedEvents   uint64
+	// LircdVersion is only known after Version was called
+	LircdVersion string
+}
+
+// Healthcheck returns the current health of the router without talking to
+// lirc daemon. PendingCommands counts commands still expecting a reply,
+// including those whose caller gave up waiting.
+func (l *Router) Healthcheck() HealthStatus {
+	status := HealthStatus{
+		Connected:     !l.closed(),
+		Uptime:        time.Since(l.started),
+		DroppedEvents: l.dropped.Load(),
+	}
+
+	if t := l.lastEvent.Load(); t != 0 {
+		status.LastEventAt = time.Unix(0, t)
+	}
+	if t := l.lastCommand.Load(); t != 0 {
+		status.LastCommandAt = time.Unix(0, t)
+	}
+
+	l.pendingLock.Lock()
+	status.PendingCommands = len(l.pending)
+	l.pendingLock.Unlock()
+
+	l.lock.RLock()
+	status.LircdVersion = l.version
+	l.lock.RUnlock()
+
+	return status
+}
+
+// Version sends a VERSION command and returns the version of lirc daemon
+func (l *Router) Version() (string, error) {
+	reply, err := l.SendCommandString("VERSION")
+	if err != nil {
+		return "", err
+	}
+	if len(reply.Data) == 0 {
+		return "", errors.New("no version in reply")
+	}
+
+	l.lock.Lock()
+	l.version = reply.Data[0]
+	l.lock.Unlock()
+
+	return reply.Data[0], nil
+}
@@ -0,0 +1,59 @@
+package lirc
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthcheck(t *testing.T) {
+	l, f := newTestRouter(t, WithEventChannelSize(1))
+	defer l.Close()
+
+	status := l.Healthcheck()
+	if !status.Connected || status.PendingCommands != 0 || status.DroppedEvents != 0 {
+		t.Fatalf("unexpected initial status %+v", status)
+	}
+	if !status.LastEventAt.IsZero() || !status.LastCommandAt.IsZero() || status.LircdVersion != "" {
+		t.Fatalf("unexpected initial status %+v", status)
+	}
+
+	start := time.Now()
+	f.event(t, "TV", "KEY_POWER", 0)
+	f.event(t, "TV", "KEY_POWER", 1)
+
+	f.setReply(func(command string) string {
+		return "BEGIN\n" + command + "\nSUCCESS\nDATA\n1\n0.10.1\nEND\n"
+	})
+	version, err := l.Version()
+	if err != nil || version != "0.10.1" {
+		t.Fatalf("got version %q, %v", version, err)
+	}
+
+	status = l.Healthcheck()
+	if status.LircdVersion != "0.10.1" {
+		t.Errorf("LircdVersion = %q, expected 0.10.1", status.LircdVersion)
+	}
+	if status.LastEventAt.Before(start) || status.LastCommandAt.Before(start) {
+		t.Errorf("event at %v, command at %v, expected after %v", status.LastEventAt, status.LastCommandAt, start)
+	}
+	if status.DroppedEvents != 1 {
+		t.Errorf("DroppedEvents = %d, expected 1", status.DroppedEvents)
+	}
+	if status.Uptime <= 0 {
+		t.Errorf("Uptime = %v", status.Uptime)
+	}
+
+	// a command without reply stays pending
+	f.setReply(func(string) string { return "" })
+	l.SendOnceBlind("TV", "KEY_POWER")
+	f.next(t)
+	f.next(t)
+	if status = l.Healthcheck(); status.PendingCommands != 1 {
+		t.Errorf("PendingCommands = %d, expected 1", status.PendingCommands)
+	}
+
+	l.Close()
+	if status = l.Healthcheck(); status.Connected {
+		t.Error("still connected after Close")
+	}
+}
@@ -0,0 +1,57 @@
+//go:build go1.23
+
+package lirc
+
+import (
+	"context"
+	"iter"
+)
+
+// Events returns an iterator over the dispatched events, which ends when ctx
+// is done or the router is closed. Events are only dispatched while Run is
+// active.
+//
+//	for event := range router.Events(ctx) {
+//		...
+//	}
+func (l *Router) Events(ctx context.Context) iter.Seq[Event] {
+	return func(yield func(Event) bool) {
+		s := l.subscribe("", nil)
+		defer l.unsubscribe(s)
+
+		for {
+			select {
+			case event, ok := <-s.events:
+				if !ok || !yield(event) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-l.done:
+				return
+			}
+		}
+	}
+}
+
+// Replies returns an iterator over the replies to all commands sent while it
+// runs, together with the command's error. It ends when ctx is done or the
+// router is closed. Like Commands, replies are dropped if the loop body is too
+// slow.
+func (l *Router) Replies(ctx context.Context) iter.Seq2[Reply, error] {
+	return func(yield func(Reply, error) bool) {
+		records := l.Commands()
+		defer l.StopCommands(records)
+
+		for {
+			select {
+			case record, ok := <-records:
+				if !ok || !yield(record.Reply, record.Err) {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+//go:build go1.23
+
+package lirc
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan Event, 3)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for event := range l.Events(ctx) {
+			events <- event
+		}
+	}()
+
+	waitSubscribed(t, l, 1)
+	for _, button := range []string{"KEY_1", "KEY_2", "KEY_3"} {
+		f.event(t, "TV", button, 0)
+	}
+	for _, button := range []string{"KEY_1", "KEY_2", "KEY_3"} {
+		if event := receiveEvent(t, events); event.Button != button {
+			t.Errorf("got %+v, expected %s", event, button)
+		}
+	}
+
+	cancel()
+	<-done
+	waitSubscribed(t, l, 0)
+}
+
+func TestReplies(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	f.failOn("KEY_BAD")
+
+	type result struct {
+		reply Reply
+		err   error
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	results := make(chan result, 2)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for reply, err := range l.Replies(ctx) {
+			results <- result{reply, err}
+		}
+	}()
+
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		l.recordLock.Lock()
+		recorders := len(l.recorders)
+		l.recordLock.Unlock()
+		if recorders == 1 {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("Replies did not start watching commands")
+		}
+	}
+
+	l.SendCommandString("VERSION")
+	l.Send("TV KEY_BAD")
+
+	for _, expected := range []string{"VERSION", "SEND_ONCE TV KEY_BAD"} {
+		select {
+		case r := <-results:
+			if r.reply.Command != expected {
+				t.Errorf("got reply to %q, expected %q", r.reply.Command, expected)
+			}
+			if failed := r.reply.Success == 0; failed != (expected == "SEND_ONCE TV KEY_BAD") {
+				t.Errorf("%s: unexpected reply %+v", expected, r.reply)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no reply to %q", expected)
+		}
+	}
+
+	cancel()
+	<-done
+}
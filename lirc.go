@@ -2,26 +2,86 @@ package lirc
 
 import (
 	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Router manages sending and receiving of commands / data
 type Router struct {
-	handlers map[remoteButton]Handle
+	lock         sync.RWMutex
+	handlers     map[RemoteButton]Handle
+	handlerNames map[RemoteButton]string
+	lastHandlers map[RemoteButton]bool
+	patterns     []patternHandler
+	topics       []topicHandler
+	remoteEvent  func(remote string, event Event)
 
+	remoteAliases   map[string]string
+	remoteLocations map[string]string
+	buttonMappings  map[string]map[string]string
+	subscriptions   map[*subscription]struct{}
+	handlerSem      chan struct{}
+	mode            string
+	modeChange      []func(oldMode string, newMode string)
+	onHandlerError  func(Event, error)
+	forgetters      []func(remote string)
+	version         string
+
+	countLock sync.Mutex
+	counts    map[RemoteButton]uint64
+	queued    map[RemoteButton]int
+
+	config     routerConfig
 	path       string
 	host       string
 	connection net.Conn
 	writer     *bufio.Writer
-	reply      chan Reply
+	cmdLock    sync.Mutex
 	receive    chan Event
-	running    bool
+	priority   chan Event
+	inject     chan Event
+	runners    atomic.Int32
+	dropped    atomic.Uint64
+	started    time.Time
+	done       chan struct{}
+	closeOnce  sync.Once
+	ready      chan struct{}
+	readyOnce  sync.Once
+
+	deadlineChanged chan struct{}
+
+	// unix nano times of the last event and command
+	lastEvent   atomic.Int64
+	lastCommand atomic.Int64
+
+	pendingLock  sync.Mutex
+	pending      []*pendingCommand
+	cancellation *cancellation
+	unsolicited  chan Reply
+
+	recordLock sync.Mutex
+	recorders  []chan CommandRecord
+
+	rawLock   sync.Mutex
+	raw       []chan string
+	rawClosed bool
+
+	profilingLock sync.Mutex
+	profiling     *http.Server
 }
 
 // Event represents the IR Remote Key Press Event
@@ -41,48 +101,83 @@ type Reply struct {
 }
 
 // Init initializes the connection to lirc daemon
-func Init(path string) (*Router, error) {
-	l := new(Router)
+//
+// Deprecated: use ConnectTo("unix", path)
+func Init(path string, opts ...Option) (*Router, error) {
+	return ConnectTo("unix", path, opts...)
+}
 
-	c, err := net.Dial("unix", path)
+// InitTCP initializes a TCP connection to lirc daemon
+//
+// Deprecated: use ConnectTo("tcp", host)
+func InitTCP(host string, opts ...Option) (*Router, error) {
+	return ConnectTo("tcp", host, opts...)
+}
 
-	if err != nil {
+// ConnectTo initializes the connection to lirc daemon. network is one of
+// "unix", "tcp", "tcp6" or "tcps" for TCP secured with TLS, see WithTLSConfig
+// and WithDialTimeout.
+func ConnectTo(network string, address string, opts ...Option) (*Router, error) {
+	config := routerConfig{eventChannelSize: defaultEventChannelSize}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if err := config.validate(); err != nil {
 		return nil, err
 	}
 
-	l.connection = c
+	var c net.Conn
+	var err error
 
-	l.path = path
+	dialer := &net.Dialer{Timeout: config.dialTimeout}
+	switch network {
+	case "unix", "tcp", "tcp6":
+		c, err = dialer.Dial(network, address)
+	case "tcps":
+		c, err = tls.DialWithDialer(dialer, "tcp", address, config.tlsConfig)
+	default:
+		return nil, fmt.Errorf("unsupported network %q, expected unix, tcp, tcp6 or tcps", network)
+	}
 
-	l.writer = bufio.NewWriter(c)
-	l.reply = make(chan Reply)
-	l.receive = make(chan Event)
+	if err != nil {
+		return nil, err
+	}
+
+	l := newRouter(c, config)
+	if network == "unix" {
+		l.path = address
+	} else {
+		l.host = address
+	}
 
 	go reader(l)
 
 	return l, nil
 }
 
-func InitTCP(host string) (*Router, error) {
+func newRouter(c net.Conn, config routerConfig) *Router {
 	l := new(Router)
 
-	c, err := net.Dial("tcp", host)
-
-	if err != nil {
-		return nil, err
-	}
-
+	l.config = config
 	l.connection = c
-
-	l.host = host
+	l.started = time.Now()
 
 	l.writer = bufio.NewWriter(c)
-	l.reply = make(chan Reply)
-	l.receive = make(chan Event)
-
-	go reader(l)
+	l.cancellation = &cancellation{done: make(chan struct{})}
+	l.receive = make(chan Event, config.eventChannelSize)
+	if config.firstPressPriority {
+		l.priority = make(chan Event, config.eventChannelSize)
+	}
+	l.inject = make(chan Event)
+	l.unsolicited = make(chan Reply, 16)
+	if config.maxHandlerGoroutines > 0 {
+		l.handlerSem = make(chan struct{}, config.maxHandlerGoroutines)
+	}
+	l.done = make(chan struct{})
+	l.deadlineChanged = make(chan struct{}, 1)
+	l.ready = make(chan struct{})
 
-	return l, nil
+	return l
 }
 
 func reader(router *Router) {
@@ -100,16 +195,51 @@ func reader(router *Router) {
 	var message Reply
 	state := RECEIVE
 	dataCnt := 0
-	scanner := bufio.NewScanner(router.connection)
-	for scanner.Scan() {
-		line := scanner.Text()
+
+	lines := make(chan string)
+	var readErr error
+	go func() {
+		readErr = readLines(router, lines)
+		close(lines)
+	}()
+
+	var timer *time.Timer
+	var expired <-chan time.Time
+read:
+	for {
+		var line string
+		select {
+		case next, ok := <-lines:
+			if !ok {
+				break read
+			}
+			line = next
+			router.sendRaw(line)
+			if timer != nil {
+				timer.Stop()
+				timer, expired = nil, nil
+			}
+		case <-expired:
+			log.Println("Invalid lirc reply message received - timeout")
+			timer, expired = nil, nil
+			state = RECEIVE
+			router.failReply(message, "timeout reading lircd reply")
+			continue
+		}
 
 		switch state {
 		case RECEIVE:
 			if line == "BEGIN" {
+				// a reply timing out before its command line matches any
+				// pending command
+				message.Command = ""
 				state = REPLY
 			} else {
 				r := strings.Split(line, " ")
+				if len(r) != 4 {
+					log.Println("Invalid lirc broadcats message received - wrong number of fields")
+					continue
+				}
 				c, err := hex.DecodeString(r[0])
 				if err != nil {
 					log.Println("Invalid lirc broadcats message received - code not parseable")
@@ -120,27 +250,23 @@ func reader(router *Router) {
 					continue
 				}
 
-				var code uint64
-				code = 0
-				for i := 0; i < 8; i++ {
-					code &= uint64(c[i]) << uint(8*i)
-				}
-
 				var event Event
 				event.Repeat, err = strconv.ParseInt(r[1], 16, 0)
 				if err != nil {
 					log.Println("Invalid lirc broadcats message received - invalid repeat count")
 				}
-				event.Code = code
+				event.Code = binary.BigEndian.Uint64(c)
 				event.Button = r[2]
 				event.Remote = r[3]
-				router.receive <- event
+				router.markReady()
+				router.lastEvent.Store(time.Now().UnixNano())
+				router.sendEvent(event)
 			}
 		case REPLY:
 			message.Command = line
 			message.Success = 0
 			message.DataLength = 0
-			message.Data = message.Data[:0]
+			message.Data = nil
 			state = STATUS
 		case STATUS:
 			if line == "SUCCESS" {
@@ -149,31 +275,36 @@ func reader(router *Router) {
 			} else if line == "END" {
 				message.Success = 1
 				state = RECEIVE
-				router.reply <- message
+				router.sendReply(message)
 			} else if line == "ERROR" {
 				message.Success = 0
 				state = DATA_START
 			} else {
 				log.Println("Invalid lirc reply message received - invalid status")
 				state = RECEIVE
+				router.failReply(message, "invalid reply status "+strconv.Quote(line))
 			}
 		case DATA_START:
 			if line == "END" {
 				state = RECEIVE
-				router.reply <- message
+				router.sendReply(message)
 			} else if line == "DATA" {
 				state = DATA_LEN
 			} else {
 				log.Println("Invalid lirc reply message received - invalid data start")
 				state = RECEIVE
+				router.failReply(message, "invalid reply data start "+strconv.Quote(line))
 			}
 		case DATA_LEN:
 			dataCnt = 0
 			var err error
 			message.DataLength, err = strconv.Atoi(line)
-			if err != nil {
+			if err != nil || message.DataLength < 0 {
 				log.Println("Invalid lirc reply message received - invalid data len")
 				state = RECEIVE
+				router.failReply(message, "invalid reply data length "+strconv.Quote(line))
+			} else if message.DataLength == 0 {
+				state = END
 			} else {
 				state = DATA
 			}
@@ -188,61 +319,763 @@ func reader(router *Router) {
 		case END:
 			state = RECEIVE
 			if line == "END" {
-				router.reply <- message
+				router.sendReply(message)
 			} else {
 				log.Println("Invalid lirc reply message received - invalid end")
+				router.failReply(message, "invalid reply end "+strconv.Quote(line))
 			}
 		}
+
+		if router.config.parseStateTimeout > 0 && state != RECEIVE {
+			timer = time.NewTimer(router.config.parseStateTimeout)
+			expired = timer.C
+		}
+	}
+	if timer != nil {
+		timer.Stop()
 	}
-	if err := scanner.Err(); err != nil {
+	if err := readErr; err != nil {
 		// only log error if the router is still in running state
-		if router.running {
+		if router.isRunning() {
 			log.Println("error reading from lircd socket")
 		}
-		router.Close()
 	} else {
 		log.Println("lircd connection error")
-		router.Close()
 	}
+	router.Close()
+	close(router.receive)
+	router.closeRaw()
+}
+
+// sendEvent queues event for Run. While Run is not active, events that do not
+// fit into the queue are dropped rather than stalling the reader.
+func (l *Router) sendEvent(event Event) {
+	queue := l.receive
+	if l.priority != nil && event.Repeat == 0 {
+		queue = l.priority
+	}
+
+	// counted before it is queued, so that Run never sees a negative count
+	l.enqueued(event)
+
+	if l.isRunning() {
+		select {
+		case queue <- event:
+		case <-l.done:
+			l.dequeued(event)
+		}
+		return
+	}
+
+	select {
+	case queue <- event:
+	default:
+		l.dequeued(event)
+		l.dropped.Add(1)
+	}
+}
+
+// enqueued counts event as waiting to be dispatched
+func (l *Router) enqueued(event Event) {
+	l.countLock.Lock()
+	defer l.countLock.Unlock()
+
+	if l.queued == nil {
+		l.queued = make(map[RemoteButton]int)
+	}
+	l.queued[RemoteButton{event.Remote, event.Button}]++
+}
+
+// dequeued counts event as taken from the queue and reports whether newer
+// events for the same button are still waiting
+func (l *Router) dequeued(event Event) bool {
+	rb := RemoteButton{event.Remote, event.Button}
+
+	l.countLock.Lock()
+	defer l.countLock.Unlock()
+
+	l.queued[rb]--
+	if l.queued[rb] > 0 {
+		return true
+	}
+	delete(l.queued, rb)
+	return false
+}
+
+// Dropped returns the number of events dropped because Run was not active
+func (l *Router) Dropped() uint64 {
+	return l.dropped.Load()
 }
 
+// WatchRaw returns a channel receiving every line read from lircd before it
+// is parsed. Lines are dropped if the channel is not drained in time. The
+// channel is closed by StopWatchRaw or when the connection is closed.
+func (l *Router) WatchRaw() <-chan string {
+	lines := make(chan string, 64)
+
+	l.rawLock.Lock()
+	defer l.rawLock.Unlock()
+
+	if l.rawClosed {
+		close(lines)
+	} else {
+		l.raw = append(l.raw, lines)
+	}
+	return lines
+}
+
+// StopWatchRaw stops the delivery of lines to a channel returned by WatchRaw
+// and closes it
+func (l *Router) StopWatchRaw(lines <-chan string) {
+	l.rawLock.Lock()
+	defer l.rawLock.Unlock()
+
+	for i, raw := range l.raw {
+		if raw == lines {
+			l.raw = append(l.raw[:i], l.raw[i+1:]...)
+			close(raw)
+			return
+		}
+	}
+}
+
+// sendRaw passes line to all WatchRaw channels
+func (l *Router) sendRaw(line string) {
+	l.rawLock.Lock()
+	defer l.rawLock.Unlock()
+
+	for _, lines := range l.raw {
+		select {
+		case lines <- line:
+		default:
+		}
+	}
+}
+
+// closeRaw closes all WatchRaw channels once the reader is done
+func (l *Router) closeRaw() {
+	l.rawLock.Lock()
+	defer l.rawLock.Unlock()
+
+	for _, lines := range l.raw {
+		close(lines)
+	}
+	l.raw = nil
+	l.rawClosed = true
+}
+
+// readLines sends each line received from lircd to lines. It returns nil once
+// the connection reached EOF. Reads that fail because a deadline set with
+// SetConnDeadline expired are resumed once the deadline is changed.
+func readLines(router *Router, lines chan<- string) error {
+	reader := bufio.NewReader(router.connection)
+	var line string
+	for {
+		s, err := reader.ReadString('\n')
+		line += s
+		if err == nil {
+			lines <- strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+			line = ""
+			continue
+		}
+
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			log.Println("lircd connection deadline exceeded")
+			select {
+			case <-router.deadlineChanged:
+				continue
+			case <-router.done:
+			}
+		}
+
+		if line != "" {
+			lines <- strings.TrimSuffix(line, "\r")
+		}
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	}
+}
+
+// sendReply hands a parsed reply to the oldest pending command
+func (l *Router) sendReply(message Reply) {
+	l.markReady()
+	if l.deliverReply(message) {
+		return
+	}
+	select {
+	case l.unsolicited <- message:
+	default:
+		log.Println("Invalid lirc reply message received - no command pending")
+	}
+}
+
+// PeekReply waits up to timeout for a reply that arrived while no command was
+// waiting for one, and reports whether there was one.
+//
+// Replies are matched to commands in the order the commands were sent, so
+// PeekReply never sees the reply to a pending Command or Send. It is meant for
+// replies to commands written to the connection by other means. Only use it
+// while no other command is in flight: if an earlier command gave up waiting
+// and its reply arrives late, that reply is discarded, not passed to PeekReply.
+func (l *Router) PeekReply(timeout time.Duration) (Reply, bool) {
+	select {
+	case reply := <-l.unsolicited:
+		return reply, true
+	default:
+	}
+	if timeout <= 0 {
+		return Reply{}, false
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-l.unsolicited:
+		return reply, true
+	case <-timer.C:
+		return Reply{}, false
+	}
+}
+
+// failReply fails the pending command message was parsed for, if any, with
+// an error reply giving reason
+func (l *Router) failReply(message Reply, reason string) {
+	message.Success = 0
+	message.Data = []string{reason}
+	message.DataLength = 1
+	l.deliverReply(message)
+}
+
+// pendingCommand is a command written to lircd whose reply has not arrived
+type pendingCommand struct {
+	command string
+	reply   chan Reply
+	// abandoned is set once nobody waits for the reply any more
+	abandoned bool
+}
+
+// deliverReply passes message to the oldest pending command. Replies always
+// arrive in the order the commands were written, but lircd may never answer
+// a command that was given up on. Such commands are skipped if message echoes
+// a different command.
+func (l *Router) deliverReply(message Reply) bool {
+	l.pendingLock.Lock()
+	for len(l.pending) > 0 {
+		p := l.pending[0]
+		if p.abandoned && message.Command != "" && message.Command != p.command {
+			l.pending = l.pending[1:]
+			continue
+		}
+		l.pending = l.pending[1:]
+		l.pendingLock.Unlock()
+
+		// buffered, so commands that gave up waiting do not block the reader
+		p.reply <- message
+		return true
+	}
+	l.pendingLock.Unlock()
+	return false
+}
+
+// abandon marks the pending command waiting on reply as given up on
+func (l *Router) abandon(reply chan Reply) {
+	l.pendingLock.Lock()
+	defer l.pendingLock.Unlock()
+
+	for _, p := range l.pending {
+		if p.reply == reply {
+			p.abandoned = true
+			return
+		}
+	}
+}
+
+// ErrTimeout is returned when lircd does not reply within the requested time
+var ErrTimeout = errors.New("timeout waiting for lircd reply")
+
+// ErrClosed is returned when the connection to lircd has been closed
+var ErrClosed = errors.New("connection closed")
+
 // Command - Send any command to lircd
+//
+// Deprecated: use SendCommandString, which also reports failures as an error
 func (l *Router) Command(command string) Reply {
+	reply, _ := l.command(command, 0)
+	return reply
+}
+
+// SendCommandString writes a raw command to lircd and waits for its reply. The
+// error is set if the command could not be sent or lircd reported an error.
+func (l *Router) SendCommandString(raw string) (Reply, error) {
+	reply, err := l.command(raw, 0)
+	if err != nil {
+		return reply, err
+	}
+	return reply, replyError(reply)
+}
+
+// command writes command to lircd and waits up to timeout for the reply. A
+// timeout of zero waits forever.
+func (l *Router) command(command string, timeout time.Duration) (Reply, error) {
+	start := time.Now()
+
+	l.cmdLock.Lock()
+	reply, cancel, err := l.write(command)
+	l.cmdLock.Unlock()
+	if err != nil {
+		r := errorReply(command, err)
+		l.record(command, start, r, err)
+		return r, err
+	}
+
+	r, err := l.wait(context.Background(), command, reply, cancel, timeout)
+	l.record(command, start, r, err)
+	return r, err
+}
+
+// CommandRecord describes a command sent to lircd and its outcome
+type CommandRecord struct {
+	Command  string
+	Reply    Reply
+	Duration time.Duration
+	Err      error
+}
+
+// Commands returns a channel receiving a record for every command sent from
+// now on. Records are dropped if the channel is not drained in time. The
+// channel is closed by StopCommands or Close.
+func (l *Router) Commands() <-chan CommandRecord {
+	records := make(chan CommandRecord, 16)
+
+	l.recordLock.Lock()
+	defer l.recordLock.Unlock()
+
+	if l.closed() {
+		close(records)
+	} else {
+		l.recorders = append(l.recorders, records)
+	}
+	return records
+}
+
+// StopCommands stops the delivery of records to a channel returned by
+// Commands and closes it
+func (l *Router) StopCommands(records <-chan CommandRecord) {
+	l.recordLock.Lock()
+	defer l.recordLock.Unlock()
+
+	for i, r := range l.recorders {
+		if r == records {
+			l.recorders = append(l.recorders[:i], l.recorders[i+1:]...)
+			close(r)
+			return
+		}
+	}
+}
+
+// record passes the outcome of command to all Commands channels
+func (l *Router) record(command string, start time.Time, reply Reply, err error) {
+	l.recordLock.Lock()
+	defer l.recordLock.Unlock()
+
+	if len(l.recorders) == 0 {
+		return
+	}
+	record := CommandRecord{
+		Command:  command,
+		Reply:    reply,
+		Duration: time.Since(start),
+		Err:      err,
+	}
+	for _, records := range l.recorders {
+		select {
+		case records <- record:
+		default:
+		}
+	}
+}
+
+// write queues a reply channel for command and writes it to lircd. The
+// caller must hold l.cmdLock so that the queue matches the write order.
+func (l *Router) write(command string) (chan Reply, *cancellation, error) {
+	reply := make(chan Reply, 1)
+	l.lastCommand.Store(time.Now().UnixNano())
+
+	l.pendingLock.Lock()
+	l.pending = append(l.pending, &pendingCommand{command: command, reply: reply})
+	cancel := l.cancellation
+	l.pendingLock.Unlock()
+
 	l.writer.WriteString(command + "\n")
-	l.writer.Flush()
+	if err := l.writer.Flush(); err != nil {
+		// drop the failed write so the next command starts clean
+		l.writer.Reset(l.connection)
 
-	reply := <-l.reply
+		l.pendingLock.Lock()
+		if n := len(l.pending); n > 0 && l.pending[n-1].reply == reply {
+			l.pending = l.pending[:n-1]
+		}
+		l.pendingLock.Unlock()
 
-	return reply
+		return nil, nil, err
+	}
+
+	return reply, cancel, nil
 }
 
-// Send a SEND_ONCE command
-func (l *Router) Send(command string) error {
-	reply := l.Command("SEND_ONCE " + command)
+// wait waits up to timeout for the reply to command, or until ctx is done. If
+// it gives up, the command stays queued as abandoned and the late reply is
+// discarded.
+func (l *Router) wait(ctx context.Context, command string, reply chan Reply, cancel *cancellation, timeout time.Duration) (Reply, error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	var err error
+	select {
+	case r := <-reply:
+		return r, nil
+	case <-l.done:
+		err = ErrClosed
+	case <-expired:
+		err = ErrTimeout
+	case <-cancel.done:
+		err = cancel.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	l.abandon(reply)
+	return errorReply(command, err), err
+}
+
+// cancellation is closed by CancelPendingCommands to release all commands
+// waiting for a reply at that time
+type cancellation struct {
+	done chan struct{}
+	err  error
+}
+
+// CancelPendingCommands makes all commands currently waiting for a reply
+// return err immediately. Their replies are discarded should they still
+// arrive. A nil err is reported as context.Canceled.
+func (l *Router) CancelPendingCommands(err error) {
+	if err == nil {
+		err = context.Canceled
+	}
+
+	l.pendingLock.Lock()
+	defer l.pendingLock.Unlock()
+
+	l.cancellation.err = err
+	close(l.cancellation.done)
+	l.cancellation = &cancellation{done: make(chan struct{})}
+}
+
+// errorReply returns an unsuccessful reply to command describing err
+func errorReply(command string, err error) Reply {
+	return Reply{Command: command, Data: []string{err.Error()}}
+}
+
+// replyError converts an unsuccessful reply into an error
+func replyError(reply Reply) error {
 	if reply.Success == 0 {
 		return errors.New(strings.Join(reply.Data, " "))
 	}
 	return nil
 }
 
+// Send a SEND_ONCE command
+func (l *Router) Send(command string) error {
+	_, err := l.SendCommandString("SEND_ONCE " + l.resolveCommand(command))
+	return err
+}
+
+// SendOptions controls a single SendOnceWithOptions call
+type SendOptions struct {
+	// Retries is the number of additional attempts after a failed send, it
+	// must not be negative
+	Retries int
+	// Timeout limits the wait for each reply, zero waits forever
+	Timeout time.Duration
+	// DryRun skips sending the command altogether
+	DryRun bool
+}
+
+// SendOnceWithOptions sends a SEND_ONCE command for button on remote using opts
+func (l *Router) SendOnceWithOptions(remote string, button string, opts SendOptions) error {
+	if opts.Retries < 0 {
+		return fmt.Errorf("SendOptions: negative Retries %d", opts.Retries)
+	}
+	if opts.DryRun {
+		return nil
+	}
+
+	var err error
+	for attempt := 0; attempt <= opts.Retries; attempt++ {
+		var reply Reply
+		reply, err = l.command("SEND_ONCE "+l.resolveRemote(remote)+" "+button, opts.Timeout)
+		if err == nil {
+			err = replyError(reply)
+		}
+		if err == nil || err == ErrClosed {
+			return err
+		}
+	}
+	return err
+}
+
+// SendOnceContext sends a SEND_ONCE command for button on remote. The deadline
+// of ctx also applies to writing the command, which can block if lircd does
+// not read from the connection. Any deadline set with SetConnDeadline is
+// removed from writes afterwards.
+func (l *Router) SendOnceContext(ctx context.Context, remote string, button string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	command := "SEND_ONCE " + l.resolveRemote(remote) + " " + button
+	start := time.Now()
+
+	l.cmdLock.Lock()
+	deadline, hasDeadline := ctx.Deadline()
+	if hasDeadline {
+		l.connection.SetWriteDeadline(deadline)
+	}
+	reply, cancel, err := l.write(command)
+	if hasDeadline {
+		l.connection.SetWriteDeadline(time.Time{})
+	}
+	l.cmdLock.Unlock()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		} else if hasDeadline && errors.Is(err, os.ErrDeadlineExceeded) {
+			// the write deadline can pass just before ctx notices
+			err = context.DeadlineExceeded
+		}
+		l.record(command, start, errorReply(command, err), err)
+		return err
+	}
+
+	r, err := l.wait(ctx, command, reply, cancel, 0)
+	l.record(command, start, r, err)
+	if err != nil {
+		return err
+	}
+	return replyError(r)
+}
+
+// SendOnceBlind writes a SEND_ONCE command for button on remote and returns
+// without waiting for the reply, which is discarded when it arrives. Only a
+// failed write is reported; whether lircd could send the button is never
+// known, and the command does not show up on the Commands channels.
+func (l *Router) SendOnceBlind(remote string, button string) error {
+	l.cmdLock.Lock()
+	defer l.cmdLock.Unlock()
+
+	reply, _, err := l.write("SEND_ONCE " + l.resolveRemote(remote) + " " + button)
+	if err != nil {
+		return err
+	}
+	l.abandon(reply)
+	return nil
+}
+
+// SendAsync sends a SEND_ONCE command for button on remote without waiting for
+// the reply. The returned channel receives the result exactly once.
+func (l *Router) SendAsync(remote string, button string) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- l.Send(remote + " " + button)
+	}()
+	return result
+}
+
 // SendLong sends a SEND_START command followed by a delay and SEND_STOP`
 func (l *Router) SendLong(command string, delay time.Duration) error {
-	reply := l.Command("SEND_START " + command)
-	if reply.Success == 0 {
-		return errors.New(strings.Join(reply.Data, " "))
+	command = l.resolveCommand(command)
+	if _, err := l.SendCommandString("SEND_START " + command); err != nil {
+		return err
 	}
 	time.Sleep(delay)
-	reply = l.Command("SEND_STOP " + command)
-	if reply.Success == 0 {
-		return errors.New(strings.Join(reply.Data, " "))
+	_, err := l.SendCommandString("SEND_STOP " + command)
+	return err
+}
+
+// SendLongProgress holds button on remote like SendLong for maxDuration, or
+// until ctx is done. onProgress is called with the elapsed time for every
+// event received for the button meanwhile, which requires Run to be active.
+// SEND_STOP is sent in any case once the button is held.
+func (l *Router) SendLongProgress(ctx context.Context, remote string, button string, onProgress func(elapsed time.Duration), maxDuration time.Duration) error {
+	if onProgress == nil {
+		return errors.New("no progress function given")
+	}
+	remote = l.resolveRemote(remote)
+	command := remote + " " + button
+
+	s := l.subscribe("", func(event Event) bool {
+		return event.Remote == remote && event.Button == button
+	})
+
+	if _, err := l.SendCommandString("SEND_START " + command); err != nil {
+		l.unsubscribe(s)
+		return err
+	}
+	start := time.Now()
+
+	timer := time.NewTimer(maxDuration)
+	defer timer.Stop()
+
+	var err error
+	events := s.events
+hold:
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			onProgress(time.Since(start))
+		case <-timer.C:
+			break hold
+		case <-ctx.Done():
+			err = ctx.Err()
+			break hold
+		}
+	}
+	// nobody reads the events any more, they must not stall dispatching
+	// while waiting for the reply to SEND_STOP
+	l.unsubscribe(s)
+
+	if _, stopErr := l.SendCommandString("SEND_STOP " + command); err == nil {
+		err = stopErr
+	}
+	return err
+}
+
+// InputLogFlag is an optional flag of the SET_INPUTLOG command
+type InputLogFlag string
+
+const (
+	// InputLogLog enables logging of the decoded input
+	InputLogLog InputLogFlag = "INPUTLOG_LOG"
+	// InputLogNoOutput suppresses the regular output while logging
+	InputLogNoOutput InputLogFlag = "INPUTLOG_NOOUTPUT"
+)
+
+// SetInputLog sends a SET_INPUTLOG command, logging lircd input to path. An
+// empty path stops logging.
+func (l *Router) SetInputLog(path string) error {
+	return l.SetInputLogWithFlags(path)
+}
+
+// SetInputLogWithFlags sends a SET_INPUTLOG command with optional flags. Flags
+// require a path.
+func (l *Router) SetInputLogWithFlags(path string, flags ...InputLogFlag) error {
+	command, err := inputLogCommand(path, flags)
+	if err != nil {
+		return err
 	}
+	_, err = l.SendCommandString(command)
+	return err
+}
 
+func inputLogCommand(path string, flags []InputLogFlag) (string, error) {
+	if path == "" {
+		if len(flags) > 0 {
+			// lircd would take the first flag for the path
+			return "", errors.New("input log flags given without a path")
+		}
+		return "SET_INPUTLOG", nil
+	}
+
+	command := "SET_INPUTLOG " + path
+	for _, flag := range flags {
+		command += " " + string(flag)
+	}
+	return command, nil
+}
+
+// SetConnDeadline sets a read and write deadline d from now on the connection
+// to lirc daemon. Reads resume once the deadline is changed again.
+func (l *Router) SetConnDeadline(d time.Duration) error {
+	return l.setDeadline(time.Now().Add(d))
+}
+
+// ResetConnDeadline removes a deadline set with SetConnDeadline
+func (l *Router) ResetConnDeadline() error {
+	return l.setDeadline(time.Time{})
+}
+
+func (l *Router) setDeadline(t time.Time) error {
+	if err := l.connection.SetDeadline(t); err != nil {
+		return err
+	}
+	select {
+	case l.deadlineChanged <- struct{}{}:
+	default:
+	}
 	return nil
 }
 
-// Close the connection to lirc daemon
+// markReady closes the ready channel on the first message from lircd
+func (l *Router) markReady() {
+	l.readyOnce.Do(func() {
+		close(l.ready)
+	})
+}
+
+// Ready returns a channel that is closed once the first event or command
+// reply has been received from lirc daemon
+func (l *Router) Ready() <-chan struct{} {
+	return l.ready
+}
+
+// WaitReady blocks until the router is ready, ctx is done or the connection
+// is closed
+func (l *Router) WaitReady(ctx context.Context) error {
+	select {
+	case <-l.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-l.done:
+		return ErrClosed
+	}
+}
+
+// closed reports whether the connection to lirc daemon has been closed
+func (l *Router) closed() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close the connection to lirc daemon. It is safe to call Close more than once.
 func (l *Router) Close() {
-	l.running = false
-	l.connection.Close()
-	close(l.receive)
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.connection.Close()
+
+		l.recordLock.Lock()
+		for _, records := range l.recorders {
+			close(records)
+		}
+		l.recorders = nil
+		l.recordLock.Unlock()
+
+		l.DisableProfiling()
+	})
 }
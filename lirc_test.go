@@ -0,0 +1,978 @@
+package lirc
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+// fakeLircd is the lircd end of a net.Pipe connected to a Router. It records
+// every command it reads and answers it with the reply returned by replyFn,
+// by default a successful reply without data.
+type fakeLircd struct {
+	conn     net.Conn
+	commands chan string
+
+	lock    sync.Mutex
+	replyFn func(command string) string
+}
+
+// newTestRouter returns a Router connected to a fake lircd. The caller must
+// Close the router.
+func newTestRouter(t *testing.T, opts ...Option) (*Router, *fakeLircd) {
+	t.Helper()
+
+	config := routerConfig{eventChannelSize: defaultEventChannelSize}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := net.Pipe()
+	l := newRouter(a, config)
+	go reader(l)
+
+	f := &fakeLircd{
+		conn:     b,
+		commands: make(chan string, 1000),
+	}
+	go f.serve()
+
+	return l, f
+}
+
+// serve answers commands until the router closes its end of the pipe
+func (f *fakeLircd) serve() {
+	defer f.conn.Close()
+
+	scanner := bufio.NewScanner(f.conn)
+	for scanner.Scan() {
+		command := scanner.Text()
+		f.commands <- command
+
+		f.lock.Lock()
+		replyFn := f.replyFn
+		f.lock.Unlock()
+
+		reply := lircdSuccess(command)
+		if replyFn != nil {
+			reply = replyFn(command)
+		}
+		if reply != "" {
+			if _, err := f.conn.Write([]byte(reply)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// setReply makes the fake answer commands with the reply returned by fn. An
+// empty reply is not sent at all.
+func (f *fakeLircd) setReply(fn func(command string) string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.replyFn = fn
+}
+
+// failOn makes the fake answer commands containing substr with an error
+func (f *fakeLircd) failOn(substr string) {
+	f.setReply(func(command string) string {
+		if strings.Contains(command, substr) {
+			return lircdError(command, "unknown command")
+		}
+		return lircdSuccess(command)
+	})
+}
+
+// send writes raw protocol lines to the router
+func (f *fakeLircd) send(t *testing.T, lines string) {
+	t.Helper()
+
+	if _, err := f.conn.Write([]byte(lines)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// event writes a broadcast message for button on remote
+func (f *fakeLircd) event(t *testing.T, remote string, button string, repeat int64) {
+	t.Helper()
+
+	f.send(t, eventLine(remote, button, repeat))
+}
+
+// next returns the next command read from the router
+func (f *fakeLircd) next(t *testing.T) string {
+	t.Helper()
+
+	select {
+	case command := <-f.commands:
+		return command
+	case <-time.After(time.Second):
+		t.Fatal("no command received")
+		return ""
+	}
+}
+
+func eventLine(remote string, button string, repeat int64) string {
+	return fmt.Sprintf("000000037ff07bef %02x %s %s\n", repeat, button, remote)
+}
+
+func lircdSuccess(command string) string {
+	return "BEGIN\n" + command + "\nSUCCESS\nEND\n"
+}
+
+func lircdError(command string, message string) string {
+	return "BEGIN\n" + command + "\nERROR\nDATA\n1\n" + message + "\nEND\n"
+}
+
+// receiveEvent waits for an event on events
+func receiveEvent(t *testing.T, events <-chan Event) Event {
+	t.Helper()
+
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no event received")
+		return Event{}
+	}
+}
+
+func TestClose(t *testing.T) {
+	l, _ := newTestRouter(t)
+	l.Close()
+	l.Close()
+
+	if _, err := l.SendCommandString("VERSION"); err == nil {
+		t.Fatal("command succeeded after Close")
+	}
+	if err := l.WaitReady(context.Background()); err != ErrClosed {
+		t.Fatalf("WaitReady returned %v, expected ErrClosed", err)
+	}
+}
+
+func TestSendAsync(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	var results []<-chan error
+	for i := 0; i < 10; i++ {
+		results = append(results, l.SendAsync("TV", fmt.Sprintf("KEY_%d", i)))
+	}
+
+	for i, result := range results {
+		if err := <-result; err != nil {
+			t.Errorf("send %d failed: %v", i, err)
+		}
+	}
+
+	sent := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		sent[f.next(t)] = true
+	}
+	for i := 0; i < 10; i++ {
+		if command := fmt.Sprintf("SEND_ONCE TV KEY_%d", i); !sent[command] {
+			t.Errorf("%q not sent", command)
+		}
+	}
+}
+
+func TestSendAsyncError(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	f.failOn("KEY_BAD")
+	if err := <-l.SendAsync("TV", "KEY_BAD"); err == nil {
+		t.Fatal("failed send reported no error")
+	}
+}
+
+func TestSendOnceWithOptions(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	if err := l.SendOnceWithOptions("TV", "KEY_POWER", SendOptions{DryRun: true}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case command := <-f.commands:
+		t.Fatalf("dry run sent %q", command)
+	default:
+	}
+
+	attempts := 0
+	f.setReply(func(command string) string {
+		attempts++
+		if attempts < 3 {
+			return lircdError(command, "transmission failed")
+		}
+		return lircdSuccess(command)
+	})
+	if err := l.SendOnceWithOptions("TV", "KEY_POWER", SendOptions{Retries: 1}); err == nil {
+		t.Fatal("send succeeded without enough retries")
+	}
+	if err := l.SendOnceWithOptions("TV", "KEY_POWER", SendOptions{Retries: 1}); err != nil {
+		t.Fatalf("retry failed: %v", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("sent %d times, expected 3", attempts)
+	}
+}
+
+func TestUnansweredCommand(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	// lircd never answers KEY_POWER
+	f.setReply(func(command string) string {
+		if strings.HasSuffix(command, "KEY_POWER") {
+			return ""
+		}
+		return lircdSuccess(command)
+	})
+
+	err := l.SendOnceWithOptions("TV", "KEY_POWER", SendOptions{Timeout: 20 * time.Millisecond})
+	if err != ErrTimeout {
+		t.Fatalf("got %v, expected %v", err, ErrTimeout)
+	}
+	if err := l.SendOnceBlind("TV", "KEY_POWER"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := l.SendOnceContext(ctx, "TV", "KEY_OK"); err != nil {
+		t.Fatalf("command after an unanswered one: %v", err)
+	}
+	if reply, err := l.SendCommandString("VERSION"); err != nil || reply.Command != "VERSION" {
+		t.Fatalf("got %+v, %v", reply, err)
+	}
+}
+
+func TestSendOnceWithOptionsNegativeRetries(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	if err := l.SendOnceWithOptions("TV", "KEY_POWER", SendOptions{Retries: -1}); err == nil {
+		t.Fatal("no error for negative retries")
+	}
+	select {
+	case command := <-f.commands:
+		t.Fatalf("sent %q with negative retries", command)
+	default:
+	}
+}
+
+func TestSendOnceWithOptionsTimeout(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	f.setReply(func(string) string { return "" })
+
+	start := time.Now()
+	err := l.SendOnceWithOptions("TV", "KEY_POWER", SendOptions{Retries: 1, Timeout: 20 * time.Millisecond})
+	if err != ErrTimeout {
+		t.Fatalf("got %v, expected ErrTimeout", err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Fatalf("returned after %v, expected two timeouts", d)
+	}
+	f.next(t)
+	f.next(t)
+}
+
+func TestParseStateTimeout(t *testing.T) {
+	l, f := newTestRouter(t, WithParseStateTimeout(20*time.Millisecond))
+	defer l.Close()
+
+	f.setReply(func(command string) string {
+		if command == "TRUNCATED" {
+			return "BEGIN\nTRUNCATED\nSUCCESS\n"
+		}
+		return lircdSuccess(command)
+	})
+
+	reply, err := l.SendCommandString("TRUNCATED")
+	if err == nil || reply.Success != 0 {
+		t.Fatalf("truncated reply reported success: %+v", reply)
+	}
+
+	if _, err := l.SendCommandString("VERSION"); err != nil {
+		t.Fatalf("no recovery after truncated reply: %v", err)
+	}
+}
+
+func TestParseStateTimeoutInvalid(t *testing.T) {
+	_, err := ConnectTo("unix", "/nonexistent", WithParseStateTimeout(-time.Second))
+	if err == nil || !strings.Contains(err.Error(), "WithParseStateTimeout") {
+		t.Fatalf("got %v, expected an error naming WithParseStateTimeout", err)
+	}
+}
+
+func TestInputLogCommand(t *testing.T) {
+	tests := []struct {
+		path    string
+		flags   []InputLogFlag
+		command string
+	}{
+		{"", nil, "SET_INPUTLOG"},
+		{"/tmp/lirc.log", nil, "SET_INPUTLOG /tmp/lirc.log"},
+		{"/tmp/lirc.log", []InputLogFlag{InputLogLog}, "SET_INPUTLOG /tmp/lirc.log INPUTLOG_LOG"},
+		{"/tmp/lirc.log", []InputLogFlag{InputLogLog, InputLogNoOutput}, "SET_INPUTLOG /tmp/lirc.log INPUTLOG_LOG INPUTLOG_NOOUTPUT"},
+	}
+
+	for _, tt := range tests {
+		command, err := inputLogCommand(tt.path, tt.flags)
+		if err != nil || command != tt.command {
+			t.Errorf("inputLogCommand(%q, %v) = %q, %v, expected %q", tt.path, tt.flags, command, err, tt.command)
+		}
+	}
+
+	if _, err := inputLogCommand("", []InputLogFlag{InputLogLog}); err == nil {
+		t.Error("flags without a path accepted")
+	}
+}
+
+func TestSetInputLogWithFlags(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	if err := l.SetInputLogWithFlags("/tmp/lirc.log", InputLogNoOutput); err != nil {
+		t.Fatal(err)
+	}
+	if command := f.next(t); command != "SET_INPUTLOG /tmp/lirc.log INPUTLOG_NOOUTPUT" {
+		t.Fatalf("lircd read %q", command)
+	}
+
+	if err := l.SetInputLogWithFlags("", InputLogLog); err == nil {
+		t.Fatal("flags without a path accepted")
+	}
+	select {
+	case command := <-f.commands:
+		t.Fatalf("%q sent for flags without a path", command)
+	default:
+	}
+}
+
+func TestConnectTo(t *testing.T) {
+	tests := []struct {
+		network string
+		listen  string
+		address string
+	}{
+		{"unix", "unix", filepath.Join(t.TempDir(), "lircd")},
+		{"tcp", "tcp", "127.0.0.1:0"},
+		{"tcp6", "tcp6", "[::1]:0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			ln, err := net.Listen(tt.listen, tt.address)
+			if err != nil {
+				t.Skip(err)
+			}
+			defer ln.Close()
+
+			accepted := make(chan net.Conn, 1)
+			go func() {
+				c, err := ln.Accept()
+				if err == nil {
+					accepted <- c
+				}
+				close(accepted)
+			}()
+
+			l, err := ConnectTo(tt.network, ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			c, ok := <-accepted
+			if !ok {
+				t.Fatal("no connection accepted")
+			}
+			defer c.Close()
+
+			if tt.network == "unix" && l.path != ln.Addr().String() {
+				t.Errorf("path = %q", l.path)
+			}
+			if tt.network != "unix" && l.host != ln.Addr().String() {
+				t.Errorf("host = %q", l.host)
+			}
+		})
+	}
+}
+
+func TestConnectToTLS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// a TLS client starts with a handshake record
+	record := make(chan byte, 1)
+	go func() {
+		defer close(record)
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		b := make([]byte, 1)
+		if _, err := c.Read(b); err == nil {
+			record <- b[0]
+		}
+	}()
+
+	if l, err := ConnectTo("tcps", ln.Addr().String()); err == nil {
+		l.Close()
+		t.Fatal("TLS handshake with a plain TCP server succeeded")
+	}
+	if b := <-record; b != 0x16 {
+		t.Fatalf("first byte sent is %#x, expected a TLS handshake", b)
+	}
+}
+
+// selfSignedCert returns a certificate for 127.0.0.1 and a pool trusting it
+func selfSignedCert(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "lircd"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestConnectToTLSConfig(t *testing.T) {
+	cert, pool := selfSignedCert(t)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer ln.Close()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f := &fakeLircd{conn: c, commands: make(chan string, 10)}
+				f.serve()
+			}()
+		}
+	}()
+
+	// the certificate is not trusted by the system roots
+	if l, err := ConnectTo("tcps", ln.Addr().String()); err == nil {
+		l.Close()
+		t.Fatal("connected without trusting the certificate")
+	}
+
+	l, err := ConnectTo("tcps", ln.Addr().String(), WithTLSConfig(&tls.Config{RootCAs: pool}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	if _, err := l.SendCommandString("VERSION"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnectToDialTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// accept the connection, but never answer the TLS handshake
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		defer close(accepted)
+		if c, err := ln.Accept(); err == nil {
+			accepted <- c
+		}
+	}()
+
+	start := time.Now()
+	l, err := ConnectTo("tcps", ln.Addr().String(), WithDialTimeout(50*time.Millisecond))
+	if err == nil {
+		l.Close()
+		t.Fatal("connected to a server that never completes the handshake")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("gave up after %v, expected the dial timeout", elapsed)
+	}
+	if c, ok := <-accepted; ok {
+		c.Close()
+	}
+}
+
+func TestConnectToUnknownNetwork(t *testing.T) {
+	_, err := ConnectTo("udp", "127.0.0.1:8765")
+	if err == nil || !strings.Contains(err.Error(), `"udp"`) {
+		t.Fatalf("got %v, expected an error naming the network", err)
+	}
+}
+
+func TestSetConnDeadline(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	if err := l.SetConnDeadline(10 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	_, err := l.SendCommandString("VERSION")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("got %v after the deadline, expected a timeout", err)
+	}
+
+	if err := l.ResetConnDeadline(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.SendCommandString("VERSION"); err != nil {
+		t.Fatalf("no recovery after ResetConnDeadline: %v", err)
+	}
+	if command := f.next(t); command != "VERSION" {
+		t.Fatalf("lircd read %q", command)
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.WaitReady(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("ready before lircd sent anything: %v", err)
+	}
+
+	ready := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		ready <- l.WaitReady(ctx)
+	}()
+
+	f.event(t, "TV", "KEY_POWER", 0)
+	if err := <-ready; err != nil {
+		t.Fatalf("not ready after an event: %v", err)
+	}
+	select {
+	case <-l.Ready():
+	default:
+		t.Fatal("Ready channel not closed")
+	}
+}
+
+func TestCancelPendingCommands(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	f.setReply(func(string) string { return "" })
+
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			_, err := l.SendCommandString("VERSION")
+			errs <- err
+		}()
+	}
+	for i := 0; i < 5; i++ {
+		f.next(t)
+	}
+
+	dropped := errors.New("connection dropped")
+	start := time.Now()
+	l.CancelPendingCommands(dropped)
+
+	for i := 0; i < 5; i++ {
+		if err := <-errs; err != dropped {
+			t.Errorf("got %v, expected the cancel error", err)
+		}
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Fatalf("commands returned after %v", d)
+	}
+}
+
+func TestSendCommandString(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	f.setReply(func(command string) string {
+		if strings.HasSuffix(command, "BAD") {
+			return lircdError(command, "unknown remote: \"BAD\"")
+		}
+		return "BEGIN\n" + command + "\nSUCCESS\nDATA\n2\nTV\nDVD\nEND\n"
+	})
+
+	for _, command := range []string{"LIST", "LIST BAD"} {
+		reply, err := l.SendCommandString(command)
+		deprecated := l.Command(command)
+
+		if !reflect.DeepEqual(reply, deprecated) {
+			t.Errorf("%s: SendCommandString got %+v, Command got %+v", command, reply, deprecated)
+		}
+		if (err != nil) != (reply.Success == 0) {
+			t.Errorf("%s: error %v does not match reply %+v", command, err, reply)
+		}
+	}
+
+	_, err := l.SendCommandString("LIST BAD")
+	if err == nil || err.Error() != "unknown remote: \"BAD\"" {
+		t.Fatalf("got %v, expected the lircd error message", err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	f.setReply(func(command string) string {
+		time.Sleep(10 * time.Millisecond)
+		if command == "SEND_ONCE TV KEY_BAD" {
+			return lircdError(command, "unknown button")
+		}
+		return lircdSuccess(command)
+	})
+
+	records := l.Commands()
+	l.Send("TV KEY_1")
+	l.Send("TV KEY_BAD")
+	l.SendCommandString("VERSION")
+
+	for _, expected := range []string{"SEND_ONCE TV KEY_1", "SEND_ONCE TV KEY_BAD", "VERSION"} {
+		var record CommandRecord
+		select {
+		case record = <-records:
+		case <-time.After(time.Second):
+			t.Fatalf("no record for %q", expected)
+		}
+		if record.Command != expected || record.Reply.Command != expected {
+			t.Errorf("got record %+v, expected one for %q", record, expected)
+		}
+		if record.Duration < 10*time.Millisecond {
+			t.Errorf("%s took %v, expected at least the reply delay", expected, record.Duration)
+		}
+		if record.Err != nil {
+			t.Errorf("%s: unexpected error %v", expected, record.Err)
+		}
+		if failed := record.Reply.Success == 0; failed != (expected == "SEND_ONCE TV KEY_BAD") {
+			t.Errorf("%s: unexpected reply %+v", expected, record.Reply)
+		}
+	}
+
+	l.Close()
+	if _, ok := <-records; ok {
+		t.Fatal("records channel not closed by Close")
+	}
+}
+
+func TestStopCommands(t *testing.T) {
+	l, _ := newTestRouter(t)
+	defer l.Close()
+
+	records := l.Commands()
+	kept := l.Commands()
+	l.StopCommands(records)
+	if _, ok := <-records; ok {
+		t.Fatal("records channel not closed by StopCommands")
+	}
+
+	l.recordLock.Lock()
+	recorders := len(l.recorders)
+	l.recordLock.Unlock()
+	if recorders != 1 {
+		t.Fatalf("%d channels registered, expected 1", recorders)
+	}
+
+	l.SendCommandString("VERSION")
+	if record := <-kept; record.Command != "VERSION" {
+		t.Fatalf("got record %+v", record)
+	}
+}
+
+func TestWatchRaw(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	lines := l.WatchRaw()
+
+	f.setReply(func(command string) string {
+		return eventLine("TV", "KEY_POWER", 0) + lircdSuccess(command)
+	})
+	if _, err := l.SendCommandString("VERSION"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"000000037ff07bef 00 KEY_POWER TV", "BEGIN", "VERSION", "SUCCESS", "END"}
+	for _, line := range expected {
+		select {
+		case got := <-lines:
+			if got != line {
+				t.Errorf("got line %q, expected %q", got, line)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no line, expected %q", line)
+		}
+	}
+
+	l.StopWatchRaw(lines)
+	if _, ok := <-lines; ok {
+		t.Error("channel not closed by StopWatchRaw")
+	}
+}
+
+func TestWatchRawAbandoned(t *testing.T) {
+	l, _ := newTestRouter(t)
+	defer l.Close()
+
+	// never read, each reply fills it by four lines
+	lines := l.WatchRaw()
+
+	for i := 0; i < 100; i++ {
+		if _, err := l.SendCommandString("VERSION"); err != nil {
+			t.Fatalf("command %d: %v", i, err)
+		}
+	}
+
+	l.Close()
+	for range lines {
+	}
+}
+
+func TestPeekReply(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	start := time.Now()
+	if reply, ok := l.PeekReply(50 * time.Millisecond); ok {
+		t.Fatalf("got unexpected reply %+v", reply)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("returned after %v, expected to wait for the timeout", elapsed)
+	}
+
+	f.send(t, "BEGIN\nSIGHUP\nEND\n")
+	if reply, ok := l.PeekReply(time.Second); !ok || reply.Command != "SIGHUP" {
+		t.Fatalf("got %+v, %v", reply, ok)
+	}
+
+	f.send(t, "BEGIN\nSIGHUP\nEND\n")
+	// wait for the reader to queue the reply, then take it without waiting
+	for deadline := time.Now().Add(time.Second); len(l.unsolicited) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("reply not queued")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if reply, ok := l.PeekReply(0); !ok || reply.Command != "SIGHUP" {
+		t.Fatalf("got %+v, %v", reply, ok)
+	}
+	if reply, ok := l.PeekReply(0); ok {
+		t.Fatalf("got unexpected reply %+v", reply)
+	}
+}
+
+func TestSendOnceBlind(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := l.SendOnceBlind("TV", "KEY_POWER"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the discarded replies do not get mixed up with later ones
+	reply, err := l.SendCommandString("VERSION")
+	if err != nil || reply.Command != "VERSION" {
+		t.Fatalf("got %+v, %v", reply, err)
+	}
+	for i := 0; i < 100; i++ {
+		if command := f.next(t); command != "SEND_ONCE TV KEY_POWER" {
+			t.Fatalf("lircd read %q", command)
+		}
+	}
+
+	l.pendingLock.Lock()
+	pending := len(l.pending)
+	l.pendingLock.Unlock()
+	if pending != 0 {
+		t.Fatalf("%d commands still pending", pending)
+	}
+}
+
+func TestSendLongProgress(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	progress := make(chan time.Duration, 5)
+	result := make(chan error, 1)
+	go func() {
+		result <- l.SendLongProgress(ctx, "TV", "KEY_VOLUMEUP", func(elapsed time.Duration) {
+			progress <- elapsed
+		}, time.Minute)
+	}()
+
+	if command := f.next(t); command != "SEND_START TV KEY_VOLUMEUP" {
+		t.Fatalf("lircd read %q", command)
+	}
+
+	var last time.Duration
+	for i := 1; i <= 5; i++ {
+		time.Sleep(5 * time.Millisecond)
+		f.event(t, "TV", "KEY_VOLUMEUP", int64(i))
+
+		select {
+		case elapsed := <-progress:
+			if elapsed <= last {
+				t.Errorf("progress %d reported %v after %v", i, elapsed, last)
+			}
+			last = elapsed
+		case <-time.After(time.Second):
+			t.Fatalf("no progress for repeat %d", i)
+		}
+	}
+
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, expected %v", err, context.Canceled)
+	}
+	if command := f.next(t); command != "SEND_STOP TV KEY_VOLUMEUP" {
+		t.Fatalf("lircd read %q", command)
+	}
+	waitSubscribed(t, l, 0)
+}
+
+func TestSendLongProgressNil(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	if err := l.SendLongProgress(context.Background(), "TV", "KEY_VOLUMEUP", nil, time.Second); err == nil {
+		t.Fatal("no error for a nil progress function")
+	}
+
+	// nothing was held
+	if _, err := l.SendCommandString("VERSION"); err != nil {
+		t.Fatal(err)
+	}
+	if command := f.next(t); command != "VERSION" {
+		t.Fatalf("lircd read %q", command)
+	}
+}
+
+func TestDropped(t *testing.T) {
+	l, f := newTestRouter(t, WithEventChannelSize(4))
+	defer l.Close()
+
+	// Run is not active, the reader must not wait for it
+	for i := 0; i < 10; i++ {
+		f.event(t, "TV", "KEY_POWER", int64(i))
+	}
+
+	// the reply is read after all events
+	if _, err := l.SendCommandString("VERSION"); err != nil {
+		t.Fatal(err)
+	}
+	if dropped := l.Dropped(); dropped != 6 {
+		t.Fatalf("dropped %d events, expected 6", dropped)
+	}
+}
+
+func TestSendOnceContextWriteDeadline(t *testing.T) {
+	config := routerConfig{eventChannelSize: defaultEventChannelSize}
+	a, b := net.Pipe()
+	l := newRouter(a, config)
+	go reader(l)
+	defer l.Close()
+
+	// nobody reads b, so writing the command blocks
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := l.SendOnceContext(ctx, "TV", "KEY_POWER")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, expected %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("returned after %v, expected the context deadline", elapsed)
+	}
+
+	// the deadline does not apply to later commands
+	f := &fakeLircd{conn: b, commands: make(chan string, 10)}
+	go f.serve()
+	if err := l.SendOnceContext(context.Background(), "TV", "KEY_POWER"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,102 @@
+// Package lircenv configures the connection to lirc daemon from environment
+// variables:
+//
+//	LIRC_SOCKET   path of the lircd unix socket, default /var/run/lirc/lircd
+//	LIRC_HOST     host[:port] of a lircd listening on TCP, default port 8765
+//	LIRC_TLS      true to connect to LIRC_HOST using TLS, default false
+//	LIRC_TIMEOUT  parse state timeout, e.g. 5s, default none
+//
+// LIRC_SOCKET and LIRC_HOST are mutually exclusive.
+package lircenv
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chbmuc/lirc"
+)
+
+// DefaultSocket is used if neither LIRC_SOCKET nor LIRC_HOST is set
+const DefaultSocket = "/var/run/lirc/lircd"
+
+// DefaultPort is added to LIRC_HOST if it does not contain a port
+const DefaultPort = "8765"
+
+// Config describes a connection to lirc daemon
+type Config struct {
+	Network string
+	Address string
+	Options []lirc.Option
+}
+
+// Connect connects to lirc daemon as described by c
+func (c Config) Connect() (*lirc.Router, error) {
+	return lirc.ConnectTo(c.Network, c.Address, c.Options...)
+}
+
+// Load reads the configuration from the process environment
+func Load() (Config, error) {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(k, "LIRC_") {
+			env[k] = v
+		}
+	}
+	return Validate(env)
+}
+
+// Validate parses the configuration from env. All invalid variables are
+// reported together in a lirc.MultiError.
+func Validate(env map[string]string) (Config, error) {
+	var errs lirc.MultiError
+	config := Config{Network: "unix", Address: DefaultSocket}
+
+	socket, hasSocket := env["LIRC_SOCKET"]
+	host, hasHost := env["LIRC_HOST"]
+	switch {
+	case hasSocket && hasHost:
+		errs = append(errs, fmt.Errorf("LIRC_SOCKET %q and LIRC_HOST %q are mutually exclusive", socket, host))
+	case hasSocket && socket == "":
+		errs = append(errs, fmt.Errorf("LIRC_SOCKET: empty path"))
+	case hasSocket:
+		config.Address = socket
+	case hasHost && host == "":
+		errs = append(errs, fmt.Errorf("LIRC_HOST: empty host"))
+	case hasHost:
+		config.Network = "tcp"
+		config.Address = host
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			config.Address = net.JoinHostPort(host, DefaultPort)
+		}
+	}
+
+	if v, ok := env["LIRC_TLS"]; ok {
+		useTLS, err := strconv.ParseBool(v)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("LIRC_TLS: invalid boolean %q", v))
+		case useTLS && !hasHost:
+			errs = append(errs, fmt.Errorf("LIRC_TLS: requires LIRC_HOST"))
+		case useTLS:
+			config.Network = "tcps"
+		}
+	}
+
+	if v, ok := env["LIRC_TIMEOUT"]; ok {
+		timeout, err := time.ParseDuration(v)
+		if err != nil || timeout < 0 {
+			errs = append(errs, fmt.Errorf("LIRC_TIMEOUT: invalid duration %q", v))
+		} else {
+			config.Options = append(config.Options, lirc.WithParseStateTimeout(timeout))
+		}
+	}
+
+	if len(errs) > 0 {
+		return Config{}, errs
+	}
+	return config, nil
+}
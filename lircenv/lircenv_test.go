@@ -0,0 +1,112 @@
+package lircenv
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/chbmuc/lirc"
+	"go.uber.org/goleak"
+)
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		network string
+		address string
+		options int
+	}{
+		{"defaults", map[string]string{}, "unix", DefaultSocket, 0},
+		{"socket", map[string]string{"LIRC_SOCKET": "/run/lirc/lircd"}, "unix", "/run/lirc/lircd", 0},
+		{"host with port", map[string]string{"LIRC_HOST": "pi:9000"}, "tcp", "pi:9000", 0},
+		{"host without port", map[string]string{"LIRC_HOST": "pi"}, "tcp", "pi:" + DefaultPort, 0},
+		{"ipv6 host", map[string]string{"LIRC_HOST": "::1"}, "tcp", "[::1]:" + DefaultPort, 0},
+		{"tls", map[string]string{"LIRC_HOST": "pi", "LIRC_TLS": "true"}, "tcps", "pi:" + DefaultPort, 0},
+		{"tls off", map[string]string{"LIRC_TLS": "false"}, "unix", DefaultSocket, 0},
+		{"timeout", map[string]string{"LIRC_TIMEOUT": "5s"}, "unix", DefaultSocket, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := Validate(tt.env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Network != tt.network || config.Address != tt.address {
+				t.Errorf("got %s %s, expected %s %s", config.Network, config.Address, tt.network, tt.address)
+			}
+			if len(config.Options) != tt.options {
+				t.Errorf("got %d options, expected %d", len(config.Options), tt.options)
+			}
+		})
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		errs []string
+	}{
+		{"socket and host", map[string]string{"LIRC_SOCKET": "/run/lircd", "LIRC_HOST": "pi"}, []string{"mutually exclusive"}},
+		{"empty socket", map[string]string{"LIRC_SOCKET": ""}, []string{"LIRC_SOCKET: empty path"}},
+		{"empty host", map[string]string{"LIRC_HOST": ""}, []string{"LIRC_HOST: empty host"}},
+		{"tls without host", map[string]string{"LIRC_TLS": "1"}, []string{"LIRC_TLS: requires LIRC_HOST"}},
+		{"invalid tls", map[string]string{"LIRC_HOST": "pi", "LIRC_TLS": "yes"}, []string{`LIRC_TLS: invalid boolean "yes"`}},
+		{"invalid timeout", map[string]string{"LIRC_TIMEOUT": "5"}, []string{`LIRC_TIMEOUT: invalid duration "5"`}},
+		{"negative timeout", map[string]string{"LIRC_TIMEOUT": "-1s"}, []string{`LIRC_TIMEOUT: invalid duration "-1s"`}},
+		{
+			"several",
+			map[string]string{"LIRC_SOCKET": "", "LIRC_TLS": "maybe", "LIRC_TIMEOUT": "soon"},
+			[]string{"LIRC_SOCKET", "LIRC_TLS", "LIRC_TIMEOUT"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Validate(tt.env)
+			var multi lirc.MultiError
+			if !errors.As(err, &multi) || len(multi) != len(tt.errs) {
+				t.Fatalf("got %v, expected %d errors", err, len(tt.errs))
+			}
+			for i, expected := range tt.errs {
+				if !strings.Contains(multi[i].Error(), expected) {
+					t.Errorf("error %q does not mention %q", multi[i], expected)
+				}
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "lircd")
+	t.Setenv("LIRC_SOCKET", socket)
+	t.Setenv("LIRC_TIMEOUT", "1s")
+
+	config, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Network != "unix" || config.Address != socket || len(config.Options) != 1 {
+		t.Fatalf("got %+v", config)
+	}
+
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	l, err := config.Connect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+}
@@ -1,66 +1,1081 @@
 package lirc
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-type remoteButton struct {
-	remote string
-	button string
+// RemoteButton identifies the remote and button a handler is registered for.
+// An asterisk matches any remote or button.
+type RemoteButton struct {
+	Remote string `json:"remote"`
+	Button string `json:"button"`
 }
 
-// Handle is a function that can be registered to handle an lirc Event
+// Handle is a function that can be registered to handle an lirc Event. It is
+// called with every event matching the remote and button it was registered
+// for.
 type Handle func(Event)
 
+// HandleFunc converts fn to a Handle
+func HandleFunc(fn func(Event)) Handle {
+	return Handle(fn)
+}
+
+// HandleMethod adapts a function or method value to a Handle. method must
+// take either no arguments or a single Event; its results are ignored.
+// HandleMethod panics if method has any other signature.
+func HandleMethod(method interface{}) Handle {
+	v := reflect.ValueOf(method)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		panic(fmt.Sprintf("lirc: HandleMethod needs a function, got %T", method))
+	}
+
+	t := v.Type()
+	switch {
+	case t.NumIn() == 0:
+		return func(Event) {
+			v.Call(nil)
+		}
+	case t.NumIn() == 1 && !t.IsVariadic() && reflect.TypeOf(Event{}).AssignableTo(t.In(0)):
+		return func(event Event) {
+			v.Call([]reflect.Value{reflect.ValueOf(event)})
+		}
+	}
+	panic(fmt.Sprintf("lirc: HandleMethod cannot call %s with an Event", t))
+}
+
 // Handle registers a new event handler for a defined key
 func (l *Router) Handle(remote string, button string, handle Handle) {
-	var rb remoteButton
+	rb := newRemoteButton(remote, button)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.setHandler(rb, handle)
+}
+
+// HandleGlob registers an event handler for all buttons matching buttonGlob,
+// using the syntax of filepath.Match, e.g. "KEY_[0-9]". A malformed pattern is
+// rejected with an error.
+func (l *Router) HandleGlob(remote string, buttonGlob string, handle Handle) error {
+	if _, err := filepath.Match(buttonGlob, ""); err != nil {
+		return fmt.Errorf("invalid button pattern %q: %v", buttonGlob, err)
+	}
+	l.Handle(remote, buttonGlob, handle)
+	return nil
+}
+
+// patternHandler is a handler registered with HandlePattern
+type patternHandler struct {
+	remote  string
+	pattern []rune
+	handle  Handle
+}
+
+// HandlePattern registers an event handler for all buttons matching
+// buttonPattern in the style of lircd config files: "*" matches any sequence
+// of characters, everything else is matched literally and case-insensitive.
+func (l *Router) HandlePattern(remote string, buttonPattern string, handle Handle) {
+	rb := newRemoteButton(remote, buttonPattern)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.patterns = append(l.patterns, patternHandler{
+		remote:  rb.Remote,
+		pattern: []rune(strings.ToLower(rb.Button)),
+		handle:  handle,
+	})
+}
+
+// matchPattern reports whether the lower case pattern matches name, see
+// HandlePattern
+func matchPattern(pattern []rune, name string) bool {
+	n := []rune(strings.ToLower(name))
+	p, i := 0, 0
+	star, retry := -1, 0
+
+	for i < len(n) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, retry = p, i
+			p++
+		case p < len(pattern) && pattern[p] == n[i]:
+			p++
+			i++
+		case star >= 0:
+			// let the last asterisk consume one more character
+			retry++
+			p, i = star+1, retry
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// newRemoteButton returns the handler key for remote and button, an empty
+// string matches anything
+func newRemoteButton(remote string, button string) RemoteButton {
+	var rb RemoteButton
 
 	if remote == "" {
-		rb.remote = "*"
+		rb.Remote = "*"
 	} else {
-		rb.remote = remote
+		rb.Remote = remote
 	}
 
 	if button == "" {
-		rb.button = "*"
+		rb.Button = "*"
 	} else {
-		rb.button = button
+		rb.Button = button
 	}
 
+	return rb
+}
+
+// HandleNamed registers a new event handler like Handle, under a name that
+// ExportHandlers can refer to
+func (l *Router) HandleNamed(remote string, button string, name string, handle Handle) {
+	rb := newRemoteButton(remote, button)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.setNamedHandler(rb, name, handle)
+}
+
+// setHandler registers handle for rb. The caller must hold l.lock.
+func (l *Router) setHandler(rb RemoteButton, handle Handle) {
 	if l.handlers == nil {
-		l.handlers = make(map[remoteButton]Handle)
+		l.handlers = make(map[RemoteButton]Handle)
 	}
 
 	l.handlers[rb] = handle
+	delete(l.handlerNames, rb)
+	delete(l.lastHandlers, rb)
+}
+
+// setNamedHandler registers handle for rb under name. The caller must hold
+// l.lock.
+func (l *Router) setNamedHandler(rb RemoteButton, name string, handle Handle) {
+	l.setHandler(rb, handle)
+
+	if l.handlerNames == nil {
+		l.handlerNames = make(map[RemoteButton]string)
+	}
+	l.handlerNames[rb] = name
+}
+
+// handlerExport is a named handler registration as written by ExportHandlers
+type handlerExport struct {
+	Remote string `json:"remote"`
+	Button string `json:"button"`
+	Name   string `json:"name"`
+}
+
+// ExportHandlers returns the handlers registered with HandleNamed as JSON.
+// Other handlers cannot be restored and are left out.
+func (l *Router) ExportHandlers() ([]byte, error) {
+	l.lock.RLock()
+	exports := make([]handlerExport, 0, len(l.handlerNames))
+	for rb, name := range l.handlerNames {
+		exports = append(exports, handlerExport{rb.Remote, rb.Button, name})
+	}
+	l.lock.RUnlock()
+
+	sort.Slice(exports, func(i, j int) bool {
+		if exports[i].Remote != exports[j].Remote {
+			return exports[i].Remote < exports[j].Remote
+		}
+		return exports[i].Button < exports[j].Button
+	})
+
+	return json.Marshal(exports)
+}
+
+// ImportHandlers registers the handlers exported by ExportHandlers, looking
+// up each handler by its name in registry. Nothing is registered if a name is
+// missing from registry.
+func (l *Router) ImportHandlers(data []byte, registry map[string]Handle) error {
+	var exports []handlerExport
+	if err := json.Unmarshal(data, &exports); err != nil {
+		return err
+	}
+
+	for _, e := range exports {
+		if registry[e.Name] == nil {
+			return fmt.Errorf("no handler named %q for %s %s", e.Name, e.Remote, e.Button)
+		}
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, e := range exports {
+		l.setNamedHandler(newRemoteButton(e.Remote, e.Button), e.Name, registry[e.Name])
+	}
+	return nil
+}
+
+// ClearHandlers removes all registered handlers, including patterns, topics
+// and the one set with HandleRemoteEvent. Pending HandleDebounced events are
+// dropped.
+func (l *Router) ClearHandlers() {
+	l.lock.Lock()
+	forgetters := l.forgetters
+	l.handlers = make(map[RemoteButton]Handle)
+	l.handlerNames = nil
+	l.lastHandlers = nil
+	l.patterns = nil
+	l.topics = nil
+	l.forgetters = nil
+	l.remoteEvent = nil
+	l.lock.Unlock()
+
+	// cancel pending debounced events of the removed handlers
+	for _, forget := range forgetters {
+		forget("")
+	}
+}
+
+// HandlerSpec describes a single handler registration for BulkHandle
+type HandlerSpec struct {
+	Remote string
+	Button string
+	Handle Handle
+}
+
+// BulkHandle registers all handlers at once. The returned slice holds one
+// entry per spec, nil if it was registered or the reason it was rejected.
+func (l *Router) BulkHandle(handlers []HandlerSpec) []error {
+	errs := make([]error, len(handlers))
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i, spec := range handlers {
+		rb := newRemoteButton(spec.Remote, spec.Button)
+		if spec.Handle == nil {
+			errs[i] = fmt.Errorf("no handler given for %s %s", rb.Remote, rb.Button)
+			continue
+		}
+		if _, err := filepath.Match(rb.Remote, ""); err != nil {
+			errs[i] = fmt.Errorf("invalid remote pattern %q: %v", spec.Remote, err)
+			continue
+		}
+		if _, err := filepath.Match(rb.Button, ""); err != nil {
+			errs[i] = fmt.Errorf("invalid button pattern %q: %v", spec.Button, err)
+			continue
+		}
+		l.setHandler(rb, spec.Handle)
+	}
+
+	return errs
+}
+
+// UpdateHandlers passes a copy of the registered handlers to fn and replaces
+// them with the result once fn returns, so that several changes become visible
+// at once. Keys are not normalized, use "*" as wildcard. fn must not call
+// other methods of the Router.
+func (l *Router) UpdateHandlers(fn func(map[RemoteButton]Handle)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	handlers := make(map[RemoteButton]Handle, len(l.handlers))
+	for k, h := range l.handlers {
+		handlers[k] = h
+	}
+	fn(handlers)
+	l.handlers = handlers
+
+	for rb := range l.handlerNames {
+		if _, ok := handlers[rb]; !ok {
+			delete(l.handlerNames, rb)
+		}
+	}
+	for rb := range l.lastHandlers {
+		if _, ok := handlers[rb]; !ok {
+			delete(l.lastHandlers, rb)
+		}
+	}
+}
+
+// Filter reports whether an event should be passed on to a handler
+type Filter func(Event) bool
+
+// FilterFirstPress passes only the initial press of a button (Repeat == 0)
+func FilterFirstPress() Filter {
+	return func(event Event) bool {
+		return event.Repeat == 0
+	}
+}
+
+// FilterRepeatRange passes events with min <= Repeat <= max
+func FilterRepeatRange(min int64, max int64) Filter {
+	return func(event Event) bool {
+		return event.Repeat >= min && event.Repeat <= max
+	}
+}
+
+// HandleWithFilter registers an event handler that is only called for events
+// accepted by filter
+func (l *Router) HandleWithFilter(remote string, button string, filter Filter, handle Handle) {
+	l.Handle(remote, button, func(event Event) {
+		if filter(event) {
+			handle(event)
+		}
+	})
+}
+
+// HandleDebounced registers an event handler that is called once per burst of
+// events, with the last event of the burst, after no further event arrived for
+// window. The handler is called from its own goroutine.
+func (l *Router) HandleDebounced(remote string, button string, window time.Duration, handle Handle) {
+	var mu sync.Mutex
+	var timer *time.Timer
+	var burst int
+	var pending string
+
+	l.addForgetter(func(remote string) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if timer != nil && (remote == "" || remote == pending) {
+			timer.Stop()
+			burst++
+		}
+	})
+
+	l.Handle(remote, button, func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if timer != nil {
+			timer.Stop()
+		}
+		burst++
+		current := burst
+		pending = event.Remote
+		timer = time.AfterFunc(window, func() {
+			mu.Lock()
+			stale := current != burst
+			mu.Unlock()
+			if !stale {
+				handle(event)
+			}
+		})
+	})
+}
+
+// HandleLast registers an event handler that only processes the most recent
+// of the queued events for a button: an event is skipped while newer events
+// for the same remote and button are still waiting to be dispatched.
+func (l *Router) HandleLast(remote string, button string, handle Handle) {
+	rb := newRemoteButton(remote, button)
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.setHandler(rb, handle)
+	if l.lastHandlers == nil {
+		l.lastHandlers = make(map[RemoteButton]bool)
+	}
+	l.lastHandlers[rb] = true
+}
+
+// HandleAsync registers an event handler that is called in a new goroutine for
+// each event, so slow handlers do not hold up dispatch. See
+// WithMaxHandlerGoroutines to limit the number of goroutines.
+func (l *Router) HandleAsync(remote string, button string, handle Handle) {
+	l.Handle(remote, button, func(event Event) {
+		if l.handlerSem == nil {
+			go handle(event)
+			return
+		}
+
+		l.handlerSem <- struct{}{}
+		go func() {
+			defer func() { <-l.handlerSem }()
+			handle(event)
+		}()
+	})
+}
+
+// HandlerMetrics is notified around each call of a handler registered with
+// HandleWithMetrics
+type HandlerMetrics interface {
+	Before(Event)
+	After(Event, time.Duration)
+}
+
+// HandleWithMetrics registers an event handler whose calls are reported to
+// metrics, e.g. to find slow handlers
+func (l *Router) HandleWithMetrics(remote string, button string, handle Handle, metrics HandlerMetrics) {
+	l.Handle(remote, button, func(event Event) {
+		metrics.Before(event)
+		start := time.Now()
+		handle(event)
+		metrics.After(event, time.Since(start))
+	})
+}
+
+// RetryableHandle is an event handler that can report a failure
+type RetryableHandle func(Event) error
+
+// HandleWithRetry registers an event handler that is called again, up to
+// retries times with delay in between, as long as it returns an error. If all
+// attempts fail, the last error is passed to the function set with
+// OnHandlerError. Retries run on their own goroutine, so other events are
+// dispatched meanwhile; they end early when the router is closed.
+func (l *Router) HandleWithRetry(remote string, button string, retries int, delay time.Duration, handle RetryableHandle) {
+	l.Handle(remote, button, func(event Event) {
+		err := handle(event)
+		if err == nil {
+			return
+		}
+		if retries <= 0 {
+			l.handlerError(event, err)
+			return
+		}
+
+		go func() {
+			timer := time.NewTimer(delay)
+			defer timer.Stop()
+
+			for attempt := 0; err != nil && attempt < retries; attempt++ {
+				if attempt > 0 {
+					timer.Reset(delay)
+				}
+				select {
+				case <-timer.C:
+				case <-l.done:
+					l.handlerError(event, err)
+					return
+				}
+				err = handle(event)
+			}
+			if err != nil {
+				l.handlerError(event, err)
+			}
+		}()
+	})
+}
+
+// OnHandlerError sets the function called when a handler registered with
+// HandleWithRetry fails for good. By default the error is logged.
+func (l *Router) OnHandlerError(fn func(Event, error)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.onHandlerError = fn
+}
+
+func (l *Router) handlerError(event Event, err error) {
+	l.lock.RLock()
+	fn := l.onHandlerError
+	l.lock.RUnlock()
+
+	if fn == nil {
+		log.Println("lirc handler for", event.Remote, event.Button, "failed:", err)
+		return
+	}
+	fn(event, err)
+}
+
+// Forget drops all state kept for the buttons of remote, e.g. after the device
+// was power cycled: pending debounced events as well as the EventCount totals.
+// An empty remote forgets the state of all remotes.
+func (l *Router) Forget(remote string) {
+	if remote != "" {
+		remote = l.resolveRemote(remote)
+	}
+
+	l.lock.RLock()
+	forgetters := l.forgetters
+	l.lock.RUnlock()
+
+	for _, forget := range forgetters {
+		forget(remote)
+	}
+
+	l.countLock.Lock()
+	for rb := range l.counts {
+		if remote == "" || rb.Remote == remote {
+			delete(l.counts, rb)
+		}
+	}
+	l.countLock.Unlock()
+}
+
+// addForgetter registers fn to be called by Forget
+func (l *Router) addForgetter(fn func(remote string)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.forgetters = append(l.forgetters, fn)
+}
+
+// topicHandler is a handler registered with HandleTopic
+type topicHandler struct {
+	topic  string
+	levels []string
+	handle Handle
+}
+
+// HandleTopic registers an event handler using an MQTT style topic. The topic
+// of an event is "remote/button", or "location/remote/button" if a location
+// was set for the remote with SetRemoteLocation. "+" matches any single level
+// and a trailing "#" matches everything below it, so "TV/+", "living/#" and
+// "+/TV/KEY_MUTE" are all valid. Everything else is matched literally.
+func (l *Router) HandleTopic(topic string, handle Handle) error {
+	levels := strings.Split(topic, "/")
+	if len(levels) > 3 {
+		return fmt.Errorf("invalid topic %q: expected [location/]remote/button", topic)
+	}
+
+	for i, level := range levels {
+		switch {
+		case level == "#":
+			if i != len(levels)-1 {
+				return fmt.Errorf("invalid topic %q: # must be the last level", topic)
+			}
+		case level == "+":
+		case level == "" || strings.ContainsAny(level, "+#"):
+			return fmt.Errorf("invalid topic %q: bad level %q", topic, level)
+		}
+	}
+	if len(levels) == 1 && levels[0] != "#" {
+		return fmt.Errorf("invalid topic %q: expected [location/]remote/button", topic)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.topics = append(l.topics, topicHandler{
+		topic:  topic,
+		levels: levels,
+		handle: handle,
+	})
+	return nil
+}
+
+// SetRemoteLocation sets the location of remote, which becomes the first
+// level of the topics of its events, see HandleTopic. An empty location
+// removes it again.
+func (l *Router) SetRemoteLocation(remote string, location string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if location == "" {
+		delete(l.remoteLocations, remote)
+		return
+	}
+	if l.remoteLocations == nil {
+		l.remoteLocations = make(map[string]string)
+	}
+	l.remoteLocations[remote] = location
+}
+
+// matchTopic reports whether the levels of a topic registered with
+// HandleTopic match the topic of an event
+func matchTopic(levels []string, topic []string) bool {
+	for i, level := range levels {
+		if level == "#" {
+			return true
+		}
+		if i >= len(topic) || (level != "+" && level != topic[i]) {
+			return false
+		}
+	}
+	return len(levels) == len(topic)
+}
+
+// HandleRemoteEvent registers fn to be called for every event, regardless of
+// remote or button, with the remote name passed as the first argument
+func (l *Router) HandleRemoteEvent(fn func(remote string, event Event)) {
+	l.lock.Lock()
+	l.remoteEvent = fn
+	l.lock.Unlock()
+}
+
+// UnhandleRemoteEvent removes the function registered with HandleRemoteEvent
+func (l *Router) UnhandleRemoteEvent() {
+	l.lock.Lock()
+	l.remoteEvent = nil
+	l.lock.Unlock()
+}
+
+// SetMode sets the current mode of the router, in the sense of lircrc modes.
+// The router itself does not interpret it; handlers can check Mode. Functions
+// registered with OnModeChange are called if the mode changes.
+func (l *Router) SetMode(mode string) {
+	l.lock.Lock()
+	old := l.mode
+	l.mode = mode
+	callbacks := l.modeChange
+	l.lock.Unlock()
+
+	if old == mode {
+		return
+	}
+	for _, fn := range callbacks {
+		fn(old, mode)
+	}
+}
+
+// Mode returns the mode set with SetMode, initially ""
+func (l *Router) Mode() string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	return l.mode
+}
+
+// OnModeChange registers fn to be called whenever SetMode changes the mode
+func (l *Router) OnModeChange(fn func(oldMode string, newMode string)) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.modeChange = append(l.modeChange, fn)
+}
+
+// AddRemoteAlias makes events from remote alias appear as events from
+// canonical, and rewrites commands sent to alias to address canonical
+func (l *Router) AddRemoteAlias(alias string, canonical string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.remoteAliases == nil {
+		l.remoteAliases = make(map[string]string)
+	}
+	l.remoteAliases[alias] = canonical
+}
+
+// HandleButtonMapping renames buttons of remote before handlers are looked up:
+// an event for a button found in mapping is dispatched with the mapped name.
+// Other buttons pass unchanged. An empty remote applies the mapping to all
+// remotes. Calling it again for the same remote adds to the mapping.
+func (l *Router) HandleButtonMapping(remote string, mapping map[string]string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.buttonMappings == nil {
+		l.buttonMappings = make(map[string]map[string]string)
+	}
+	m := l.buttonMappings[remote]
+	if m == nil {
+		m = make(map[string]string, len(mapping))
+		l.buttonMappings[remote] = m
+	}
+	for from, to := range mapping {
+		m[from] = to
+	}
+}
+
+// SetButtonAlias makes events for button alias on remote appear as events for
+// button canonical. Several aliases may share a canonical name. An empty
+// remote applies the alias to all remotes.
+func (l *Router) SetButtonAlias(remote string, alias string, canonical string) {
+	l.HandleButtonMapping(remote, map[string]string{alias: canonical})
+}
+
+// resolveRemote returns the canonical name of remote
+func (l *Router) resolveRemote(remote string) string {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	if canonical, ok := l.remoteAliases[remote]; ok {
+		return canonical
+	}
+	return remote
+}
+
+// resolveCommand replaces the remote in a "remote button" command argument
+// with its canonical name
+func (l *Router) resolveCommand(command string) string {
+	remote, rest, found := strings.Cut(command, " ")
+	if !found {
+		return l.resolveRemote(command)
+	}
+	return l.resolveRemote(remote) + " " + rest
+}
+
+// subscription receives the events accepted by filter from dispatch. Events
+// for a lossy subscription are dropped if its buffer is full.
+type subscription struct {
+	name   string
+	filter func(Event) bool
+	events chan Event
+	done   chan struct{}
+	lossy  bool
+}
+
+// subscribe starts delivering events accepted by filter, or all events if
+// filter is nil, until unsubscribe is called
+func (l *Router) subscribe(name string, filter func(Event) bool) *subscription {
+	s := &subscription{
+		name:   name,
+		filter: filter,
+		events: make(chan Event),
+		done:   make(chan struct{}),
+	}
+	l.addSubscription(s)
+	return s
+}
+
+// addSubscription starts delivering events to s
+func (l *Router) addSubscription(s *subscription) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.subscriptions == nil {
+		l.subscriptions = make(map[*subscription]struct{})
+	}
+	l.subscriptions[s] = struct{}{}
+}
+
+// unsubscribe stops the delivery of events to s. The channel of a lossy
+// subscription is closed, unless closeSubscriptions did so already.
+func (l *Router) unsubscribe(s *subscription) {
+	l.lock.Lock()
+	if _, ok := l.subscriptions[s]; ok && s.lossy {
+		close(s.events)
+	}
+	delete(l.subscriptions, s)
+	l.lock.Unlock()
+	close(s.done)
+}
+
+// WatchUntil waits for the first event accepted by predicate. It returns an
+// error if ctx is done or the connection is closed first. Events are only
+// received while Run is active.
+func (l *Router) WatchUntil(ctx context.Context, predicate func(Event) bool) (Event, error) {
+	s := l.subscribe("", predicate)
+	defer l.unsubscribe(s)
+
+	select {
+	case event, ok := <-s.events:
+		if !ok {
+			return Event{}, ErrClosed
+		}
+		return event, nil
+	case <-ctx.Done():
+		return Event{}, ctx.Err()
+	case <-l.done:
+		return Event{}, ErrClosed
+	}
+}
+
+// WatchGroup returns a channel receiving the events for any of buttons on
+// remote, or on any remote if remote is empty. name only serves to identify
+// the group in Dump. A button may be part of several groups. The channel
+// buffers as many events as set with WithEventChannelSize; further events are
+// dropped until it is drained. It is closed once ctx is done, the router is
+// closed or Run returns.
+func (l *Router) WatchGroup(ctx context.Context, name string, remote string, buttons []string) <-chan Event {
+	members := make(map[string]bool, len(buttons))
+	for _, button := range buttons {
+		members[button] = true
+	}
+
+	s := &subscription{
+		name: name,
+		filter: func(event Event) bool {
+			return (remote == "" || event.Remote == remote) && members[event.Button]
+		},
+		events: make(chan Event, l.config.eventChannelSize),
+		done:   make(chan struct{}),
+		lossy:  true,
+	}
+	l.addSubscription(s)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-l.done:
+		}
+		l.unsubscribe(s)
+	}()
+
+	return s.events
 }
 
 // Run this in a go routine to listen for IR Key Press Events
 func (l *Router) Run() {
-	l.running = true
-	var rb remoteButton
+	l.run(context.Background())
+}
+
+// HandlerMap holds handlers by remote and button, see Listen
+type HandlerMap = map[string]map[string]Handle
+
+// savedHandler is the registration of a button replaced by Listen
+type savedHandler struct {
+	handle Handle
+	name   string
+	named  bool
+	last   bool
+}
+
+// isRunning reports whether Run or Listen dispatches events
+func (l *Router) isRunning() bool {
+	return l.runners.Load() > 0 && !l.closed()
+}
+
+// Listen registers all handlers, dispatches events like Run until ctx is done
+// or the router is closed, and restores the handlers registered before for the
+// same buttons. It returns the context error if ctx is done, nil if the router
+// was closed.
+func (l *Router) Listen(ctx context.Context, handlers HandlerMap) error {
+	saved := make(map[RemoteButton]*savedHandler)
+
+	l.lock.Lock()
+	for remote, buttons := range handlers {
+		for button, handle := range buttons {
+			rb := newRemoteButton(remote, button)
+			if _, ok := saved[rb]; !ok {
+				saved[rb] = nil
+				if h, ok := l.handlers[rb]; ok {
+					name, named := l.handlerNames[rb]
+					saved[rb] = &savedHandler{h, name, named, l.lastHandlers[rb]}
+				}
+			}
+			l.setHandler(rb, handle)
+		}
+	}
+	l.lock.Unlock()
+
+	defer func() {
+		l.lock.Lock()
+		defer l.lock.Unlock()
+
+		for rb, h := range saved {
+			if h == nil {
+				delete(l.handlers, rb)
+				continue
+			}
+			if h.named {
+				l.setNamedHandler(rb, h.name, h.handle)
+			} else {
+				l.setHandler(rb, h.handle)
+			}
+			if h.last {
+				if l.lastHandlers == nil {
+					l.lastHandlers = make(map[RemoteButton]bool)
+				}
+				l.lastHandlers[rb] = true
+			}
+		}
+	}()
+
+	return l.run(ctx)
+}
+
+// run dispatches events until ctx is done or the connection closed. Several
+// calls may run at the same time, e.g. Run and Listen.
+func (l *Router) run(ctx context.Context) error {
+	l.runners.Add(1)
+	// let the reader drop events again instead of blocking once the last
+	// one returns
+	defer l.runners.Add(-1)
 
 	for {
-		event, success := <-l.receive
-		if !success {
-			break
-		}
-		// Check for exact match
-		rb.remote = event.Remote
-		rb.button = event.Button
-		if h, ok := l.handlers[rb]; ok {
-			h(event)
+		var event Event
+		var superseded bool
+		select {
+		case event = <-l.priority:
+			superseded = l.dequeued(event)
+		default:
+			select {
+			case received, success := <-l.receive:
+				if !success {
+					l.drainPriority()
+					l.closeSubscriptions()
+					return nil
+				}
+				event = received
+				superseded = l.dequeued(event)
+			case event = <-l.priority:
+				superseded = l.dequeued(event)
+			case event = <-l.inject:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		l.dispatch(event, superseded)
+	}
+}
+
+// drainPriority dispatches the first presses still queued when the
+// connection closed
+func (l *Router) drainPriority() {
+	for {
+		select {
+		case event := <-l.priority:
+			l.dispatch(event, l.dequeued(event))
+		default:
+			return
+		}
+	}
+}
+
+// closeSubscriptions closes the channels of all subscriptions once Run is
+// done dispatching
+func (l *Router) closeSubscriptions() {
+	l.lock.Lock()
+	for s := range l.subscriptions {
+		close(s.events)
+	}
+	l.subscriptions = nil
+	l.lock.Unlock()
+}
+
+// dispatch calls all handlers registered for event. If newer events for the
+// button are queued, the event is superseded and handlers registered with
+// HandleLast are skipped.
+func (l *Router) dispatch(event Event, superseded bool) {
+	l.lock.RLock()
+	if canonical, ok := l.remoteAliases[event.Remote]; ok {
+		event.Remote = canonical
+	}
+	if button, ok := l.buttonMappings[event.Remote][event.Button]; ok {
+		event.Button = button
+	} else if button, ok := l.buttonMappings[""][event.Button]; ok {
+		event.Button = button
+	}
+	remoteEvent := l.remoteEvent
+	handles := l.match(event, superseded)
+	subscriptions := make([]*subscription, 0, len(l.subscriptions))
+	for s := range l.subscriptions {
+		subscriptions = append(subscriptions, s)
+	}
+	l.lock.RUnlock()
+
+	l.countLock.Lock()
+	if l.counts == nil {
+		l.counts = make(map[RemoteButton]uint64)
+	}
+	l.counts[RemoteButton{event.Remote, event.Button}]++
+	l.countLock.Unlock()
+
+	if remoteEvent != nil {
+		remoteEvent(event.Remote, event)
+	}
+	for _, h := range handles {
+		h(event)
+	}
+	lossy := false
+	for _, s := range subscriptions {
+		if s.lossy {
+			lossy = true
+			continue
+		}
+		if s.filter != nil && !s.filter(event) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		case <-s.done:
+		case <-l.done:
+		}
+	}
+	if lossy {
+		l.deliverLossy(event)
+	}
+}
+
+// deliverLossy passes event to the lossy subscriptions without blocking. It
+// holds l.lock so that unsubscribe cannot close a channel meanwhile.
+func (l *Router) deliverLossy(event Event) {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	for s := range l.subscriptions {
+		if !s.lossy || (s.filter != nil && !s.filter(event)) {
+			continue
+		}
+		select {
+		case s.events <- event:
+		default:
+		}
+	}
+}
+
+// EventCount returns the number of events dispatched for button on remote
+// since the router started, or since Forget was last called for the remote.
+// An empty remote or button counts all of them.
+func (l *Router) EventCount(remote string, button string) uint64 {
+	l.countLock.Lock()
+	defer l.countLock.Unlock()
+
+	if remote != "" && button != "" {
+		return l.counts[RemoteButton{remote, button}]
+	}
+
+	var total uint64
+	for rb, n := range l.counts {
+		if (remote == "" || rb.Remote == remote) && (button == "" || rb.Button == button) {
+			total += n
+		}
+	}
+	return total
+}
+
+// match returns the handlers registered for event, an exact match takes
+// precedence over pattern matches. HandleLast handlers are left out for
+// superseded events. The caller must hold l.lock.
+func (l *Router) match(event Event, superseded bool) []Handle {
+	// Check for exact match
+	rb := RemoteButton{event.Remote, event.Button}
+	if h, ok := l.handlers[rb]; ok {
+		if superseded && l.lastHandlers[rb] {
+			return nil
+		}
+		return []Handle{h}
+	}
+
+	// Check for pattern matches
+	var handles []Handle
+	for k, h := range l.handlers {
+		if superseded && l.lastHandlers[k] {
 			continue
 		}
+		remoteMatched, _ := filepath.Match(k.Remote, event.Remote)
+		buttonMatched, _ := filepath.Match(k.Button, event.Button)
 
-		// Check for pattern matches
-		for k, h := range l.handlers {
-			remoteMatched, _ := filepath.Match(k.remote, event.Remote)
-			buttonMatched, _ := filepath.Match(k.button, event.Button)
+		if remoteMatched && buttonMatched {
+			handles = append(handles, h)
+		}
+	}
+	for _, p := range l.patterns {
+		remoteMatched, _ := filepath.Match(p.remote, event.Remote)
 
-			if remoteMatched && buttonMatched {
-				h(event)
+		if remoteMatched && matchPattern(p.pattern, event.Button) {
+			handles = append(handles, p.handle)
+		}
+	}
+	if len(l.topics) > 0 {
+		topic := []string{event.Remote, event.Button}
+		if location, ok := l.remoteLocations[event.Remote]; ok {
+			topic = append([]string{location}, topic...)
+		}
+		for _, h := range l.topics {
+			if matchTopic(h.levels, topic) {
+				handles = append(handles, h.handle)
 			}
 		}
 	}
+	return handles
 }
@@ -0,0 +1,1167 @@
+package lirc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// dispatchEvents sends events from lircd to the running router l and waits
+// until each of them has passed all handlers
+func dispatchEvents(t *testing.T, l *Router, f *fakeLircd, events ...Event) {
+	t.Helper()
+
+	s := l.subscribe("", nil)
+	defer l.unsubscribe(s)
+
+	for _, event := range events {
+		f.event(t, event.Remote, event.Button, event.Repeat)
+		receiveEvent(t, s.events)
+	}
+}
+
+func TestHandleRemoteEvent(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	var remotes []string
+	l.HandleRemoteEvent(func(remote string, event Event) {
+		if remote != event.Remote {
+			t.Errorf("called with remote %q for event of %q", remote, event.Remote)
+		}
+		remotes = append(remotes, remote)
+	})
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_POWER"},
+		Event{Remote: "DVD", Button: "KEY_PLAY"},
+	)
+	if len(remotes) != 2 || remotes[0] != "TV" || remotes[1] != "DVD" {
+		t.Fatalf("called for %q, expected TV and DVD", remotes)
+	}
+
+	l.UnhandleRemoteEvent()
+	dispatchEvents(t, l, f, Event{Remote: "TV", Button: "KEY_POWER"})
+	if len(remotes) != 2 {
+		t.Fatalf("called after UnhandleRemoteEvent for %q", remotes[2:])
+	}
+}
+
+func TestHandleWithFilter(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	var first, ranged []int64
+	l.HandleWithFilter("TV", "KEY_UP", FilterFirstPress(), func(event Event) {
+		first = append(first, event.Repeat)
+	})
+	l.HandleWithFilter("TV", "KEY_DOWN", FilterRepeatRange(1, 2), func(event Event) {
+		ranged = append(ranged, event.Repeat)
+	})
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_UP", Repeat: 0},
+		Event{Remote: "TV", Button: "KEY_UP", Repeat: 2},
+		Event{Remote: "TV", Button: "KEY_DOWN", Repeat: 0},
+		Event{Remote: "TV", Button: "KEY_DOWN", Repeat: 1},
+		Event{Remote: "TV", Button: "KEY_DOWN", Repeat: 2},
+		Event{Remote: "TV", Button: "KEY_DOWN", Repeat: 3},
+	)
+
+	if len(first) != 1 || first[0] != 0 {
+		t.Errorf("FilterFirstPress passed repeats %v, expected [0]", first)
+	}
+	if len(ranged) != 2 || ranged[0] != 1 || ranged[1] != 2 {
+		t.Errorf("FilterRepeatRange(1, 2) passed repeats %v, expected [1 2]", ranged)
+	}
+}
+
+func TestHandleTopic(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	l.SetRemoteLocation("TV", "living")
+	l.SetRemoteLocation("DVD", "living")
+	l.SetRemoteLocation("AMP", "kitchen")
+
+	fired := make(map[string][]string)
+	for _, topic := range []string{"living/#", "+/TV/KEY_MUTE", "AUX/+", "AUX/KEY_*"} {
+		err := l.HandleTopic(topic, func(event Event) {
+			fired[topic] = append(fired[topic], event.Remote+" "+event.Button)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_MUTE"},
+		Event{Remote: "DVD", Button: "KEY_PLAY"},
+		Event{Remote: "AMP", Button: "KEY_MUTE"},
+		Event{Remote: "AUX", Button: "KEY_1"},
+	)
+
+	expected := map[string][]string{
+		"living/#":      {"TV KEY_MUTE", "DVD KEY_PLAY"},
+		"+/TV/KEY_MUTE": {"TV KEY_MUTE"},
+		"AUX/+":         {"AUX KEY_1"},
+	}
+	if !reflect.DeepEqual(fired, expected) {
+		t.Fatalf("fired %v, expected %v", fired, expected)
+	}
+}
+
+func TestHandleTopicInvalid(t *testing.T) {
+	l, _ := newTestRouter(t)
+	defer l.Close()
+
+	for _, topic := range []string{"TV", "", "TV/", "#/KEY_1", "TV/KEY+", "a/b/c/d", "TV/##"} {
+		if err := l.HandleTopic(topic, func(Event) {}); err == nil {
+			t.Errorf("topic %q accepted", topic)
+		}
+	}
+}
+
+func TestUpdateHandlers(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	fired := make(map[string]int)
+	l.Handle("TV", "KEY_OLD", func(Event) {})
+
+	// dispatch concurrently to let -race check the swap of the handler map
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			l.dispatch(Event{Remote: "TV", Button: "KEY_OLD"}, false)
+		}
+	}()
+
+	l.UpdateHandlers(func(handlers map[RemoteButton]Handle) {
+		delete(handlers, RemoteButton{"TV", "KEY_OLD"})
+		for i := 0; i < 10; i++ {
+			button := fmt.Sprintf("KEY_%d", i)
+			handlers[RemoteButton{"TV", button}] = func(event Event) {
+				fired[event.Button]++
+			}
+		}
+	})
+	<-done
+
+	var events []Event
+	for i := 0; i < 10; i++ {
+		events = append(events, Event{Remote: "TV", Button: fmt.Sprintf("KEY_%d", i)})
+	}
+	dispatchEvents(t, l, f, events...)
+	if len(fired) != 10 {
+		t.Fatalf("%d of 10 handlers fired", len(fired))
+	}
+
+	var state routerState
+	dump, _ := l.Dump()
+	if err := json.Unmarshal(dump, &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Handlers) != 10 {
+		t.Fatalf("%d handlers registered, expected 10", len(state.Handlers))
+	}
+}
+
+// waitSubscribed waits until n subscriptions are registered with l
+func waitSubscribed(t *testing.T, l *Router, n int) {
+	t.Helper()
+
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(time.Millisecond) {
+		l.lock.RLock()
+		subscribed := len(l.subscriptions)
+		l.lock.RUnlock()
+		if subscribed == n {
+			return
+		}
+	}
+	t.Fatalf("expected %d subscriptions", n)
+}
+
+func TestWatchUntil(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	type result struct {
+		event Event
+		err   error
+	}
+	results := make(chan result)
+	go func() {
+		event, err := l.WatchUntil(context.Background(), func(event Event) bool {
+			return event.Repeat > 3
+		})
+		results <- result{event, err}
+	}()
+	waitSubscribed(t, l, 1)
+
+	for repeat := int64(0); repeat < 7; repeat++ {
+		f.event(t, "TV", "KEY_UP", repeat)
+	}
+
+	r := <-results
+	if r.err != nil || r.event.Repeat != 4 {
+		t.Fatalf("got %+v, %v, expected the event with repeat 4", r.event, r.err)
+	}
+	waitSubscribed(t, l, 0)
+}
+
+func TestWatchUntilCanceled(t *testing.T) {
+	l, _ := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := l.WatchUntil(ctx, func(Event) bool { return true }); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, expected context.DeadlineExceeded", err)
+	}
+	waitSubscribed(t, l, 0)
+
+	l.Close()
+	if _, err := l.WatchUntil(context.Background(), func(Event) bool { return true }); err != ErrClosed {
+		t.Fatalf("got %v after Close, expected ErrClosed", err)
+	}
+}
+
+func TestHandleDebounced(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	volume := make(chan Event, 10)
+	power := make(chan Event, 10)
+	l.HandleDebounced("TV", "KEY_VOLUMEUP", 50*time.Millisecond, func(event Event) {
+		volume <- event
+	})
+	l.HandleDebounced("TV", "KEY_POWER", 150*time.Millisecond, func(event Event) {
+		power <- event
+	})
+
+	var events []Event
+	for repeat := int64(0); repeat < 5; repeat++ {
+		events = append(events,
+			Event{Remote: "TV", Button: "KEY_VOLUMEUP", Repeat: repeat},
+			Event{Remote: "TV", Button: "KEY_POWER", Repeat: repeat},
+		)
+	}
+	start := time.Now()
+	dispatchEvents(t, l, f, events...)
+
+	if event := receiveEvent(t, volume); event.Repeat != 4 {
+		t.Errorf("volume handler called with repeat %d, expected the last one", event.Repeat)
+	}
+	if event := receiveEvent(t, power); event.Repeat != 4 {
+		t.Errorf("power handler called with repeat %d, expected the last one", event.Repeat)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("power handler called after %v, before its window", d)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if len(volume) != 0 || len(power) != 0 {
+		t.Fatalf("handlers called again: %d volume, %d power", len(volume), len(power))
+	}
+}
+
+func TestBulkHandle(t *testing.T) {
+	l, _ := newTestRouter(t)
+	defer l.Close()
+
+	var specs []HandlerSpec
+	for i := 0; i < 100; i++ {
+		specs = append(specs, HandlerSpec{Remote: "TV", Button: fmt.Sprintf("KEY_%d", i), Handle: func(Event) {}})
+	}
+	specs = append(specs,
+		HandlerSpec{Remote: "TV", Button: "KEY_NIL"},
+		HandlerSpec{Remote: "TV[", Button: "KEY_1", Handle: func(Event) {}},
+		HandlerSpec{Remote: "TV", Button: "KEY_[", Handle: func(Event) {}},
+	)
+
+	errs := l.BulkHandle(specs)
+	if len(errs) != len(specs) {
+		t.Fatalf("got %d errors for %d specs", len(errs), len(specs))
+	}
+	for i, err := range errs[:100] {
+		if err != nil {
+			t.Errorf("spec %d rejected: %v", i, err)
+		}
+	}
+	for i, err := range errs[100:] {
+		if err == nil {
+			t.Errorf("invalid spec %+v accepted", specs[100+i])
+		}
+	}
+
+	l.lock.RLock()
+	registered := len(l.handlers)
+	l.lock.RUnlock()
+	if registered != 100 {
+		t.Fatalf("%d handlers registered, expected 100", registered)
+	}
+}
+
+func TestClearHandlers(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	fired := 0
+	handle := func(Event) { fired++ }
+	l.Handle("TV", "KEY_POWER", handle)
+	l.Handle("", "", handle)
+	l.HandlePattern("TV", "*POWER*", handle)
+	if err := l.HandleTopic("TV/#", handle); err != nil {
+		t.Fatal(err)
+	}
+	l.HandleRemoteEvent(func(string, Event) { fired++ })
+
+	debounced := make(chan Event, 1)
+	l.HandleDebounced("TV", "KEY_MUTE", 50*time.Millisecond, func(event Event) {
+		debounced <- event
+	})
+	dispatchEvents(t, l, f, Event{Remote: "TV", Button: "KEY_MUTE"})
+	fired = 0
+
+	l.ClearHandlers()
+
+	var state routerState
+	dump, _ := l.Dump()
+	if err := json.Unmarshal(dump, &state); err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Handlers) != 0 || len(state.Patterns) != 0 || len(state.Topics) != 0 || state.RemoteEvent {
+		t.Fatalf("handlers left after ClearHandlers: %s", dump)
+	}
+
+	dispatchEvents(t, l, f, Event{Remote: "TV", Button: "KEY_POWER"})
+	if fired != 0 {
+		t.Fatalf("%d handlers fired after ClearHandlers", fired)
+	}
+
+	select {
+	case event := <-debounced:
+		t.Fatalf("debounced handler fired for %+v after ClearHandlers", event)
+	case <-time.After(150 * time.Millisecond):
+	}
+}
+
+func TestAddRemoteAlias(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	var fired []Event
+	l.Handle("TV", "KEY_POWER", func(event Event) {
+		fired = append(fired, event)
+	})
+	l.AddRemoteAlias("TELEVISION", "TV")
+
+	dispatchEvents(t, l, f, Event{Remote: "TELEVISION", Button: "KEY_POWER"})
+	if len(fired) != 1 || fired[0].Remote != "TV" {
+		t.Fatalf("TV handler got %+v for an event of TELEVISION", fired)
+	}
+
+	if err := l.Send("TELEVISION KEY_POWER"); err != nil {
+		t.Fatal(err)
+	}
+	if command := f.next(t); command != "SEND_ONCE TV KEY_POWER" {
+		t.Fatalf("lircd read %q, expected the canonical remote", command)
+	}
+}
+
+func TestHandleGlob(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	fired := make(map[string]bool)
+	if err := l.HandleGlob("TV", "KEY_[0-9]", func(event Event) {
+		fired[event.Button] = true
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []Event
+	for i := 0; i < 10; i++ {
+		events = append(events, Event{Remote: "TV", Button: fmt.Sprintf("KEY_%d", i)})
+	}
+	events = append(events, Event{Remote: "TV", Button: "KEY_OK"}, Event{Remote: "TV", Button: "KEY_10"})
+	dispatchEvents(t, l, f, events...)
+
+	for i := 0; i < 10; i++ {
+		if button := fmt.Sprintf("KEY_%d", i); !fired[button] {
+			t.Errorf("not fired for %s", button)
+		}
+	}
+	if fired["KEY_OK"] || fired["KEY_10"] {
+		t.Errorf("fired for %v", fired)
+	}
+
+	if err := l.HandleGlob("TV", "KEY_[", func(Event) {}); err == nil {
+		t.Error("malformed pattern accepted")
+	}
+}
+
+type buttonRecorder struct {
+	buttons []string
+	calls   int
+}
+
+func (r *buttonRecorder) Record(event Event) {
+	r.buttons = append(r.buttons, event.Button)
+}
+
+func (r *buttonRecorder) Count() int {
+	r.calls++
+	return r.calls
+}
+
+func TestHandleFuncAndMethod(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	var fromFunc []string
+	recorder := &buttonRecorder{}
+	l.Handle("TV", "KEY_1", HandleFunc(func(event Event) {
+		fromFunc = append(fromFunc, event.Button)
+	}))
+	l.Handle("TV", "KEY_2", HandleMethod(recorder.Record))
+	l.Handle("TV", "KEY_3", HandleMethod(recorder.Count))
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_1"},
+		Event{Remote: "TV", Button: "KEY_2"},
+		Event{Remote: "TV", Button: "KEY_3"},
+		Event{Remote: "TV", Button: "KEY_3"},
+	)
+
+	if len(fromFunc) != 1 || fromFunc[0] != "KEY_1" {
+		t.Errorf("HandleFunc handle got %q", fromFunc)
+	}
+	if len(recorder.buttons) != 1 || recorder.buttons[0] != "KEY_2" {
+		t.Errorf("HandleMethod handle got %q", recorder.buttons)
+	}
+	if recorder.calls != 2 {
+		t.Errorf("method without arguments called %d times, expected 2", recorder.calls)
+	}
+}
+
+func TestHandleMethodInvalid(t *testing.T) {
+	for _, method := range []interface{}{
+		nil,
+		"KEY_1",
+		func(string) {},
+		func(Event, Event) {},
+		func(...Event) {},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("HandleMethod(%T) did not panic", method)
+				}
+			}()
+			HandleMethod(method)
+		}()
+	}
+}
+
+func TestEventCount(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	var events []Event
+	for i := 0; i < 5; i++ {
+		events = append(events, Event{Remote: "TV", Button: "KEY_A"})
+	}
+	for i := 0; i < 3; i++ {
+		events = append(events, Event{Remote: "TV", Button: "KEY_B"})
+	}
+	events = append(events, Event{Remote: "DVD", Button: "KEY_A"})
+	dispatchEvents(t, l, f, events...)
+
+	for _, tt := range []struct {
+		remote string
+		button string
+		count  uint64
+	}{
+		{"TV", "KEY_A", 5},
+		{"TV", "KEY_B", 3},
+		{"TV", "", 8},
+		{"", "KEY_A", 6},
+		{"", "", 9},
+		{"DVD", "KEY_B", 0},
+	} {
+		if n := l.EventCount(tt.remote, tt.button); n != tt.count {
+			t.Errorf("EventCount(%q, %q) = %d, expected %d", tt.remote, tt.button, n, tt.count)
+		}
+	}
+}
+
+func TestHandleLast(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	var repeats []int64
+	l.HandleLast("TV", "KEY_VOLUMEUP", func(event Event) {
+		repeats = append(repeats, event.Repeat)
+	})
+	done := make(chan Event)
+	l.Handle("TV", "KEY_DONE", func(event Event) {
+		done <- event
+	})
+
+	// queue all events before Run dispatches any of them
+	for repeat := int64(0); repeat < 10; repeat++ {
+		f.event(t, "TV", "KEY_VOLUMEUP", repeat)
+	}
+	for start := time.Now(); ; time.Sleep(time.Millisecond) {
+		l.countLock.Lock()
+		queued := l.queued[RemoteButton{"TV", "KEY_VOLUMEUP"}]
+		l.countLock.Unlock()
+		if queued == 10 {
+			break
+		}
+		if time.Since(start) > time.Second {
+			t.Fatalf("%d events queued, expected 10", queued)
+		}
+	}
+
+	go l.Run()
+	f.event(t, "TV", "KEY_DONE", 0)
+	receiveEvent(t, done)
+
+	if len(repeats) != 1 || repeats[0] != 9 {
+		t.Fatalf("handled repeats %v, expected only the last one", repeats)
+	}
+
+	// an event that is not superseded is handled right away
+	f.event(t, "TV", "KEY_VOLUMEUP", 0)
+	f.event(t, "TV", "KEY_DONE", 0)
+	receiveEvent(t, done)
+	if len(repeats) != 2 {
+		t.Fatalf("handled repeats %v, expected a second call", repeats)
+	}
+}
+
+func TestHandlePattern(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	fired := make(map[string]bool)
+	l.HandlePattern("TV", "*POWER*", func(event Event) {
+		fired[event.Button] = true
+	})
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_POWER"},
+		Event{Remote: "TV", Button: "POWER_ON"},
+		Event{Remote: "TV", Button: "ALLPOWER"},
+		Event{Remote: "TV", Button: "key_power2"},
+		Event{Remote: "TV", Button: "KEY_VOLUME"},
+		Event{Remote: "DVD", Button: "KEY_POWER"},
+	)
+
+	expected := map[string]bool{"KEY_POWER": true, "POWER_ON": true, "ALLPOWER": true, "key_power2": true}
+	if !reflect.DeepEqual(fired, expected) {
+		t.Fatalf("fired for %v, expected %v", fired, expected)
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"*", "", true},
+		{"*", "KEY_OK", true},
+		{"key_ok", "KEY_OK", true},
+		{"KEY_OK", "KEY_OK2", false},
+		{"KEY_*", "KEY_", true},
+		{"*_UP", "KEY_VOLUME_UP", true},
+		{"*_UP", "KEY_UP_DOWN", false},
+		{"A*B*C", "AxxBxxBxxC", true},
+		{"A*B*C", "AxxCxxB", false},
+		{"KEY_[0-9]", "KEY_1", false},
+		{"KEY_[0-9]", "KEY_[0-9]", true},
+		{"KEY_.+", "KEY_.+", true},
+		{"KEY_.+", "KEY_AB", false},
+		{"LAUTSTÄRKE*", "lautstärke_hoch", true},
+	}
+
+	for _, tt := range tests {
+		pattern := []rune(strings.ToLower(tt.pattern))
+		if match := matchPattern(pattern, tt.name); match != tt.match {
+			t.Errorf("matchPattern(%q, %q) = %v, expected %v", tt.pattern, tt.name, match, tt.match)
+		}
+	}
+}
+
+func TestWatchGroup(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	playback := l.WatchGroup(ctx, "playback", "DVD", []string{"KEY_PLAY", "KEY_STOP"})
+	stop := l.WatchGroup(ctx, "stop", "", []string{"KEY_STOP", "KEY_POWER"})
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "DVD", Button: "KEY_STOP"},
+		Event{Remote: "TV", Button: "KEY_PLAY"},
+	)
+
+	if event := receiveEvent(t, playback); event.Button != "KEY_STOP" {
+		t.Errorf("playback got %+v", event)
+	}
+	if event := receiveEvent(t, stop); event.Button != "KEY_STOP" {
+		t.Errorf("stop got %+v", event)
+	}
+	if len(playback) != 0 || len(stop) != 0 {
+		t.Errorf("events of other buttons delivered")
+	}
+
+	cancel()
+	for range playback {
+	}
+	for range stop {
+	}
+	waitSubscribed(t, l, 0)
+}
+
+func TestWatchGroupUnread(t *testing.T) {
+	l, f := newTestRouter(t, WithEventChannelSize(2))
+	defer l.Close()
+	go l.Run()
+
+	// a group nobody reads must not hold up dispatch
+	group := l.WatchGroup(context.Background(), "unread", "", []string{"KEY_A"})
+
+	fired := 0
+	l.Handle("", "", func(Event) {
+		fired++
+	})
+
+	var events []Event
+	for i := 0; i < 5; i++ {
+		events = append(events, Event{Remote: "TV", Button: "KEY_A"})
+	}
+	dispatchEvents(t, l, f, events...)
+
+	if fired != 5 {
+		t.Fatalf("handler fired %d of 5 times", fired)
+	}
+	if len(group) != 2 {
+		t.Fatalf("group buffered %d events, expected 2", len(group))
+	}
+
+	l.Close()
+	for range group {
+	}
+}
+
+func TestHandleButtonMapping(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	var fired []Event
+	l.Handle("TV", "KEY_ONE", func(event Event) {
+		fired = append(fired, event)
+	})
+	l.Handle("TV", "KEY_1", func(event Event) {
+		t.Errorf("handler of the unmapped button got %+v", event)
+	})
+	l.HandleButtonMapping("TV", map[string]string{"KEY_1": "KEY_ONE"})
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_1"},
+		Event{Remote: "DVD", Button: "KEY_1"},
+		Event{Remote: "TV", Button: "KEY_ONE"},
+	)
+	if len(fired) != 2 || fired[0].Button != "KEY_ONE" {
+		t.Fatalf("KEY_ONE handler got %+v, expected the mapped and the plain event", fired)
+	}
+}
+
+func TestHandleAsync(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	var wg sync.WaitGroup
+	wg.Add(10)
+	l.HandleAsync("TV", "KEY_POWER", func(Event) {
+		defer wg.Done()
+		time.Sleep(100 * time.Millisecond)
+	})
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		f.event(t, "TV", "KEY_POWER", int64(i))
+	}
+	wg.Wait()
+
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("10 handlers took %v, expected them to run concurrently", elapsed)
+	}
+}
+
+func TestHandleAsyncMaxGoroutines(t *testing.T) {
+	l, f := newTestRouter(t, WithMaxHandlerGoroutines(2))
+	defer l.Close()
+	go l.Run()
+
+	var lock sync.Mutex
+	var running, peak int
+	var wg sync.WaitGroup
+	wg.Add(6)
+	l.HandleAsync("TV", "KEY_POWER", func(Event) {
+		defer wg.Done()
+
+		lock.Lock()
+		running++
+		peak = max(peak, running)
+		lock.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+
+		lock.Lock()
+		running--
+		lock.Unlock()
+	})
+
+	for i := 0; i < 6; i++ {
+		f.event(t, "TV", "KEY_POWER", int64(i))
+	}
+	wg.Wait()
+
+	if peak != 2 {
+		t.Fatalf("at most %d handlers ran at once, expected 2", peak)
+	}
+}
+
+// metricsRecorder records the calls of a HandlerMetrics
+type metricsRecorder struct {
+	calls     []string
+	durations []time.Duration
+}
+
+func (r *metricsRecorder) Before(event Event) {
+	r.calls = append(r.calls, "before "+event.Button)
+}
+
+func (r *metricsRecorder) After(event Event, d time.Duration) {
+	r.calls = append(r.calls, "after "+event.Button)
+	r.durations = append(r.durations, d)
+}
+
+func TestHandleWithMetrics(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	metrics := &metricsRecorder{}
+	l.HandleWithMetrics("TV", "KEY_POWER", func(Event) {
+		metrics.calls = append(metrics.calls, "handle")
+		time.Sleep(10 * time.Millisecond)
+	}, metrics)
+
+	dispatchEvents(t, l, f, Event{Remote: "TV", Button: "KEY_POWER"})
+
+	expected := []string{"before KEY_POWER", "handle", "after KEY_POWER"}
+	if !reflect.DeepEqual(metrics.calls, expected) {
+		t.Fatalf("got calls %q, expected %q", metrics.calls, expected)
+	}
+	if metrics.durations[0] < 10*time.Millisecond {
+		t.Fatalf("reported %v, expected at least the handler's duration", metrics.durations[0])
+	}
+}
+
+func TestOnModeChange(t *testing.T) {
+	l, _ := newTestRouter(t)
+	defer l.Close()
+
+	var changes [][2]string
+	l.OnModeChange(func(oldMode string, newMode string) {
+		if mode := l.Mode(); mode != newMode {
+			t.Errorf("Mode() = %q while changing to %q", mode, newMode)
+		}
+		changes = append(changes, [2]string{oldMode, newMode})
+	})
+
+	l.SetMode("tv")
+	l.SetMode("tv")
+	l.SetMode("radio")
+
+	expected := [][2]string{{"", "tv"}, {"tv", "radio"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("got changes %q, expected %q", changes, expected)
+	}
+}
+
+func TestExportImportHandlers(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	var fired []string
+	registry := map[string]Handle{
+		"power": func(event Event) { fired = append(fired, "power "+event.Remote) },
+		"mute":  func(event Event) { fired = append(fired, "mute "+event.Remote) },
+	}
+	l.HandleNamed("TV", "KEY_POWER", "power", registry["power"])
+	l.HandleNamed("DVD", "KEY_POWER", "power", registry["power"])
+	l.HandleNamed("TV", "KEY_MUTE", "mute", registry["mute"])
+	l.Handle("TV", "KEY_OK", func(Event) {})
+
+	data, err := l.ExportHandlers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.ClearHandlers()
+
+	if err := l.ImportHandlers(data, map[string]Handle{"power": registry["power"]}); err == nil {
+		t.Fatal("imported handlers with a name missing from the registry")
+	}
+	if err := l.ImportHandlers(data, registry); err != nil {
+		t.Fatal(err)
+	}
+
+	reexported, err := l.ExportHandlers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reexported) != string(data) {
+		t.Fatalf("exported %s after import, expected %s", reexported, data)
+	}
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_POWER"},
+		Event{Remote: "DVD", Button: "KEY_POWER"},
+		Event{Remote: "TV", Button: "KEY_MUTE"},
+		Event{Remote: "TV", Button: "KEY_OK"},
+	)
+	expected := []string{"power TV", "power DVD", "mute TV"}
+	if !reflect.DeepEqual(fired, expected) {
+		t.Fatalf("fired %q, expected %q", fired, expected)
+	}
+}
+
+func TestHandleWithRetry(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	failures := make(chan error, 1)
+	l.OnHandlerError(func(event Event, err error) {
+		failures <- err
+	})
+
+	succeeded := make(chan int, 1)
+	calls := 0
+	l.HandleWithRetry("TV", "KEY_POWER", 3, time.Millisecond, func(Event) error {
+		calls++
+		if calls < 3 {
+			return fmt.Errorf("attempt %d failed", calls)
+		}
+		succeeded <- calls
+		return nil
+	})
+	dispatchEvents(t, l, f, Event{Remote: "TV", Button: "KEY_POWER"})
+	select {
+	case n := <-succeeded:
+		if n != 3 {
+			t.Fatalf("succeeded on call %d, expected the third", n)
+		}
+	case err := <-failures:
+		t.Fatalf("failed with %v, expected success on the third call", err)
+	case <-time.After(time.Second):
+		t.Fatal("handler not retried")
+	}
+
+	attempts := 0
+	l.HandleWithRetry("TV", "KEY_MUTE", 2, time.Millisecond, func(Event) error {
+		attempts++
+		return fmt.Errorf("attempt %d failed", attempts)
+	})
+	dispatchEvents(t, l, f, Event{Remote: "TV", Button: "KEY_MUTE"})
+	select {
+	case err := <-failures:
+		if err.Error() != "attempt 3 failed" {
+			t.Fatalf("OnHandlerError got %v, expected the last error", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnHandlerError not called")
+	}
+}
+
+func TestHandleWithRetryNotBlocking(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	failures := make(chan error, 1)
+	l.OnHandlerError(func(event Event, err error) {
+		failures <- err
+	})
+	l.HandleWithRetry("TV", "KEY_POWER", 3, time.Minute, func(Event) error {
+		return errors.New("device busy")
+	})
+
+	// other events and commands are handled while the handler waits to retry
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_POWER"},
+		Event{Remote: "TV", Button: "KEY_MUTE"},
+	)
+	if _, err := l.SendCommandString("VERSION"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close ends the wait with the last error
+	l.Close()
+	select {
+	case err := <-failures:
+		if err.Error() != "device busy" {
+			t.Fatalf("OnHandlerError got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("retry not ended by Close")
+	}
+}
+
+func TestFirstPressPriority(t *testing.T) {
+	l, f := newTestRouter(t, WithFirstPressPriority(), WithEventChannelSize(64))
+	defer l.Close()
+
+	for i := 1; i <= 50; i++ {
+		f.event(t, "TV", "KEY_VOLUMEUP", int64(i))
+	}
+	f.event(t, "TV", "KEY_POWER", 0)
+	// the reply is read after all events are queued
+	if _, err := l.SendCommandString("VERSION"); err != nil {
+		t.Fatal(err)
+	}
+
+	s := l.subscribe("", nil)
+	defer l.unsubscribe(s)
+	go l.Run()
+
+	if event := receiveEvent(t, s.events); event.Button != "KEY_POWER" {
+		t.Fatalf("got %+v first, expected the first press", event)
+	}
+	for i := 1; i <= 50; i++ {
+		if event := receiveEvent(t, s.events); event.Repeat != int64(i) {
+			t.Fatalf("got %+v, expected repeat %d", event, i)
+		}
+	}
+}
+
+func TestForget(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	fired := make(chan string, 2)
+	for _, remote := range []string{"TV", "DVD"} {
+		l.HandleDebounced(remote, "KEY_POWER", 100*time.Millisecond, func(event Event) {
+			fired <- event.Remote
+		})
+	}
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_POWER"},
+		Event{Remote: "TV", Button: "KEY_MUTE"},
+		Event{Remote: "DVD", Button: "KEY_POWER"},
+	)
+	l.Forget("TV")
+
+	select {
+	case remote := <-fired:
+		if remote != "DVD" {
+			t.Fatalf("debounced handler fired for %s after Forget", remote)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("debounced handler of DVD not fired")
+	}
+	select {
+	case remote := <-fired:
+		t.Fatalf("debounced handler fired for %s after Forget", remote)
+	case <-time.After(250 * time.Millisecond):
+	}
+
+	if n := l.EventCount("TV", ""); n != 0 {
+		t.Errorf("TV still counts %d events", n)
+	}
+	if n := l.EventCount("DVD", "KEY_POWER"); n != 1 {
+		t.Errorf("DVD counts %d events, expected 1", n)
+	}
+}
+
+func TestSetButtonAlias(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	var fired []string
+	l.Handle("TV", "KEY_VOLUMEUP", func(event Event) {
+		fired = append(fired, event.Button)
+	})
+	l.SetButtonAlias("TV", "VOLUMEUP", "KEY_VOLUMEUP")
+	l.SetButtonAlias("", "VOL+", "KEY_VOLUMEUP")
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "VOLUMEUP"},
+		Event{Remote: "TV", Button: "VOL+"},
+		Event{Remote: "TV", Button: "KEY_VOLUMEUP"},
+	)
+	expected := []string{"KEY_VOLUMEUP", "KEY_VOLUMEUP", "KEY_VOLUMEUP"}
+	if !reflect.DeepEqual(fired, expected) {
+		t.Fatalf("handler got %q, expected %q", fired, expected)
+	}
+}
+
+func TestListen(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	var fired []string
+	l.HandleNamed("TV", "KEY_POWER", "power", func(Event) {
+		fired = append(fired, "before")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- l.Listen(ctx, HandlerMap{
+			"TV": {
+				"KEY_POWER": func(Event) { fired = append(fired, "listen power") },
+				"KEY_MUTE":  func(Event) { fired = append(fired, "listen mute") },
+			},
+		})
+	}()
+	for start := time.Now(); !l.isRunning(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("Listen did not start")
+		}
+	}
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_POWER"},
+		Event{Remote: "TV", Button: "KEY_MUTE"},
+	)
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Fatalf("got %v, expected %v", err, context.Canceled)
+	}
+
+	data, err := l.ExportHandlers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := `[{"remote":"TV","button":"KEY_POWER","name":"power"}]`; string(data) != expected {
+		t.Errorf("exported %s after Listen, expected %s", data, expected)
+	}
+
+	go l.Run()
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_POWER"},
+		Event{Remote: "TV", Button: "KEY_MUTE"},
+	)
+
+	expected := []string{"listen power", "listen mute", "before"}
+	if !reflect.DeepEqual(fired, expected) {
+		t.Fatalf("fired %q, expected %q", fired, expected)
+	}
+}
+
+func TestListenClearHandlers(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+
+	l.HandleNamed("TV", "KEY_POWER", "power", func(Event) {})
+	l.HandleLast("TV", "KEY_MUTE", func(Event) {})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- l.Listen(ctx, HandlerMap{
+			"TV": {
+				"KEY_POWER": func(Event) {},
+				"KEY_MUTE":  func(Event) {},
+			},
+		})
+	}()
+	for start := time.Now(); !l.isRunning(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("Listen did not start")
+		}
+	}
+
+	l.ClearHandlers()
+	dispatchEvents(t, l, f, Event{Remote: "TV", Button: "KEY_POWER"})
+	cancel()
+	if err := <-result; err != context.Canceled {
+		t.Fatalf("got %v, expected %v", err, context.Canceled)
+	}
+
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	if l.handlerNames[newRemoteButton("TV", "KEY_POWER")] != "power" || !l.lastHandlers[newRemoteButton("TV", "KEY_MUTE")] {
+		t.Fatalf("handlers not restored: names %v, last %v", l.handlerNames, l.lastHandlers)
+	}
+}
+
+func TestListenWhileRunning(t *testing.T) {
+	l, f := newTestRouter(t, WithEventChannelSize(1))
+	defer l.Close()
+	go l.Run()
+	for start := time.Now(); !l.isRunning(); time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("Run did not start")
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Listen(ctx, HandlerMap{}); err != context.Canceled {
+		t.Fatalf("got %v, expected %v", err, context.Canceled)
+	}
+	if !l.isRunning() {
+		t.Fatal("Listen returning stopped Run")
+	}
+
+	// Run still takes every event, the reader does not drop them
+	s := l.subscribe("", nil)
+	defer l.unsubscribe(s)
+	for i := 0; i < 10; i++ {
+		f.event(t, "TV", "KEY_POWER", int64(i))
+		receiveEvent(t, s.events)
+	}
+	if dropped := l.Dropped(); dropped != 0 {
+		t.Fatalf("dropped %d events while Run was active", dropped)
+	}
+}
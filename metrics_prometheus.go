@@ -0,0 +1,53 @@
+//go:build prometheus
+
+package lirc
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	prometheusOnce  sync.Once
+	handlerCalls    *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+)
+
+func registerPrometheusMetrics() {
+	handlerCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "lirc_handler_calls_total",
+		Help: "Number of lirc event handler calls.",
+	}, []string{"remote", "button"})
+	handlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "lirc_handler_duration_seconds",
+		Help:    "Duration of lirc event handler calls.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"remote", "button"})
+	prometheus.MustRegister(handlerCalls, handlerDuration)
+}
+
+type prometheusHandlerMetrics struct {
+	calls    prometheus.Counter
+	duration prometheus.Observer
+}
+
+// PrometheusHandlerMetrics returns HandlerMetrics counting calls and
+// recording durations in the default prometheus registry, labelled with
+// remote and button. Only available when built with the prometheus tag.
+func PrometheusHandlerMetrics(remote string, button string) HandlerMetrics {
+	prometheusOnce.Do(registerPrometheusMetrics)
+	return prometheusHandlerMetrics{
+		calls:    handlerCalls.WithLabelValues(remote, button),
+		duration: handlerDuration.WithLabelValues(remote, button),
+	}
+}
+
+func (m prometheusHandlerMetrics) Before(Event) {
+	m.calls.Inc()
+}
+
+func (m prometheusHandlerMetrics) After(_ Event, d time.Duration) {
+	m.duration.Observe(d.Seconds())
+}
@@ -0,0 +1,32 @@
+//go:build prometheus
+
+package lirc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestPrometheusHandlerMetrics(t *testing.T) {
+	l, f := newTestRouter(t)
+	defer l.Close()
+	go l.Run()
+
+	l.HandleWithMetrics("TV", "KEY_POWER", func(Event) {
+		time.Sleep(time.Millisecond)
+	}, PrometheusHandlerMetrics("TV", "KEY_POWER"))
+
+	dispatchEvents(t, l, f,
+		Event{Remote: "TV", Button: "KEY_POWER"},
+		Event{Remote: "TV", Button: "KEY_POWER", Repeat: 1},
+	)
+
+	if calls := testutil.ToFloat64(handlerCalls.WithLabelValues("TV", "KEY_POWER")); calls != 2 {
+		t.Errorf("counted %v calls, expected 2", calls)
+	}
+	if n := testutil.CollectAndCount(handlerDuration, "lirc_handler_duration_seconds"); n != 1 {
+		t.Errorf("got %d duration series, expected 1", n)
+	}
+}
@@ -0,0 +1,130 @@
+package lirc
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Option configures a Router when it is created
+type Option func(*routerConfig)
+
+type routerConfig struct {
+	parseStateTimeout    time.Duration
+	sendAllConcurrency   int
+	maxHandlerGoroutines int
+	eventChannelSize     int
+	firstPressPriority   bool
+	tlsConfig            *tls.Config
+	dialTimeout          time.Duration
+}
+
+const (
+	defaultSendAllConcurrency = 4
+	defaultEventChannelSize   = 16
+)
+
+// validate reports all invalid option values at once
+func (c *routerConfig) validate() error {
+	var errs MultiError
+
+	if c.parseStateTimeout < 0 {
+		errs = append(errs, fmt.Errorf("WithParseStateTimeout: negative duration %v", c.parseStateTimeout))
+	}
+	if c.sendAllConcurrency < 0 {
+		errs = append(errs, fmt.Errorf("WithSendAllConcurrency: negative concurrency %d", c.sendAllConcurrency))
+	}
+
+	if c.maxHandlerGoroutines < 0 {
+		errs = append(errs, fmt.Errorf("WithMaxHandlerGoroutines: negative limit %d", c.maxHandlerGoroutines))
+	}
+
+	if c.eventChannelSize < 0 {
+		errs = append(errs, fmt.Errorf("WithEventChannelSize: negative size %d", c.eventChannelSize))
+	}
+
+	if c.dialTimeout < 0 {
+		errs = append(errs, fmt.Errorf("WithDialTimeout: negative duration %v", c.dialTimeout))
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// MultiError collects several errors, e.g. all invalid options passed to
+// ConnectTo
+type MultiError []error
+
+func (m MultiError) Error() string {
+	msgs := make([]string, len(m))
+	for i, err := range m {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the collected errors for errors.Is and errors.As
+func (m MultiError) Unwrap() []error {
+	return m
+}
+
+// WithParseStateTimeout resets the reply parser if lircd stops sending in the
+// middle of a reply for longer than d. The pending command fails with an
+// error reply. A duration of zero disables the timeout.
+func WithParseStateTimeout(d time.Duration) Option {
+	return func(c *routerConfig) {
+		c.parseStateTimeout = d
+	}
+}
+
+// WithSendAllConcurrency sets the number of commands SendAll keeps in flight
+func WithSendAllConcurrency(n int) Option {
+	return func(c *routerConfig) {
+		c.sendAllConcurrency = n
+	}
+}
+
+// WithMaxHandlerGoroutines limits the number of handlers registered with
+// HandleAsync running at the same time. Dispatch waits while the limit is
+// reached. Zero means no limit.
+func WithMaxHandlerGoroutines(n int) Option {
+	return func(c *routerConfig) {
+		c.maxHandlerGoroutines = n
+	}
+}
+
+// WithEventChannelSize sets the number of received events queued for Run,
+// default 16. While Run is not active, events beyond that are dropped.
+func WithEventChannelSize(n int) Option {
+	return func(c *routerConfig) {
+		c.eventChannelSize = n
+	}
+}
+
+// WithFirstPressPriority dispatches first presses (Repeat == 0) ahead of
+// queued repeat events
+func WithFirstPressPriority() Option {
+	return func(c *routerConfig) {
+		c.firstPressPriority = true
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used by ConnectTo for the "tcps"
+// network, e.g. to trust a private CA or present a client certificate. By
+// default the system roots are used.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *routerConfig) {
+		c.tlsConfig = config
+	}
+}
+
+// WithDialTimeout limits the time ConnectTo waits for the connection to be
+// established, including the TLS handshake. Zero means no limit.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *routerConfig) {
+		c.dialTimeout = d
+	}
+}
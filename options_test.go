@@ -0,0 +1,55 @@
+package lirc
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOptionsValidate(t *testing.T) {
+	_, err := ConnectTo("unix", "/nonexistent",
+		WithParseStateTimeout(-time.Second),
+		WithSendAllConcurrency(-1),
+		WithMaxHandlerGoroutines(-2),
+		WithEventChannelSize(-3),
+		WithDialTimeout(-time.Minute),
+	)
+
+	var multi MultiError
+	if !errors.As(err, &multi) {
+		t.Fatalf("got %v, expected a MultiError", err)
+	}
+	if len(multi) != 5 {
+		t.Fatalf("got %d errors, expected 5: %v", len(multi), err)
+	}
+	for _, expected := range []string{
+		"WithParseStateTimeout: negative duration -1s",
+		"WithSendAllConcurrency: negative concurrency -1",
+		"WithMaxHandlerGoroutines: negative limit -2",
+		"WithEventChannelSize: negative size -3",
+		"WithDialTimeout: negative duration -1m0s",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q missing in %q", expected, err)
+		}
+	}
+}
+
+func TestOptionsValid(t *testing.T) {
+	config := routerConfig{eventChannelSize: defaultEventChannelSize}
+	for _, opt := range []Option{
+		WithParseStateTimeout(time.Second),
+		WithSendAllConcurrency(8),
+		WithMaxHandlerGoroutines(0),
+		WithEventChannelSize(0),
+		WithFirstPressPriority(),
+		WithTLSConfig(nil),
+		WithDialTimeout(time.Second),
+	} {
+		opt(&config)
+	}
+	if err := config.validate(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,50 @@
+package lirc
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/pprof"
+)
+
+// EnableProfiling serves the net/http/pprof handlers below /debug/pprof/ at
+// addr until DisableProfiling or Close is called
+func (l *Router) EnableProfiling(addr string) error {
+	l.profilingLock.Lock()
+	defer l.profilingLock.Unlock()
+
+	if l.profiling != nil {
+		return errors.New("profiling already enabled")
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	l.profiling = &http.Server{Addr: ln.Addr().String(), Handler: mux}
+	go l.profiling.Serve(ln)
+
+	return nil
+}
+
+// DisableProfiling stops the server started by EnableProfiling
+func (l *Router) DisableProfiling() error {
+	l.profilingLock.Lock()
+	defer l.profilingLock.Unlock()
+
+	if l.profiling == nil {
+		return errors.New("profiling not enabled")
+	}
+
+	err := l.profiling.Close()
+	l.profiling = nil
+	return err
+}
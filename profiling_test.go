@@ -0,0 +1,47 @@
+package lirc
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestEnableProfiling(t *testing.T) {
+	l, _ := newTestRouter(t)
+	defer l.Close()
+
+	if err := l.EnableProfiling("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.EnableProfiling("127.0.0.1:0"); err == nil {
+		t.Error("enabled profiling twice")
+	}
+
+	l.profilingLock.Lock()
+	url := "http://" + l.profiling.Addr + "/debug/pprof/"
+	l.profilingLock.Unlock()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, expected %d", resp.StatusCode, http.StatusOK)
+	}
+	client.CloseIdleConnections()
+
+	if err := l.DisableProfiling(); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.DisableProfiling(); err == nil {
+		t.Error("disabled profiling twice")
+	}
+
+	if resp, err := client.Get(url); err == nil {
+		resp.Body.Close()
+		t.Error("profiling still served after DisableProfiling")
+	}
+}